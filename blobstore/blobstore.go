@@ -0,0 +1,17 @@
+package blobstore
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	ErrNotFound = errors.New("Blob does not exist")
+)
+
+// Stores attachment data by key
+type BlobStore interface {
+	Put(key string, reader io.Reader, size int64, contentType string) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
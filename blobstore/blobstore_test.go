@@ -0,0 +1,164 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Stands in for an S3-compatible service, keeping objects in memory
+type fakeS3 struct {
+	lock sync.Mutex
+	objects map[string][]byte
+	accessKey string
+	secretKey string
+	region string
+}
+
+// Recomputes the Signature V4 signature from the request as received and compares it with the one sent
+func (fake *fakeS3) verify(request *http.Request) bool {
+	var credential, signedHeaders, signature string
+	authorization := strings.TrimPrefix(request.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	for _, part := range strings.Split(authorization, ", ") {
+		if pair := strings.SplitN(part, "=", 2); len(pair) == 2 {
+			switch pair[0] {
+			case "Credential":
+				credential = pair[1]
+			case "SignedHeaders":
+				signedHeaders = pair[1]
+			case "Signature":
+				signature = pair[1]
+			}
+		}
+	}
+
+	amzDate := request.Header.Get("X-Amz-Date")
+	if len(amzDate) < 8 {
+		return false
+	}
+	scope := amzDate[:8] + "/" + fake.region + "/s3/aws4_request"
+	if credential != fake.accessKey + "/" + scope {
+		return false
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := request.Header.Get(name)
+		if name == "host" {
+			value = request.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		request.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	key := []byte("AWS4" + fake.secretKey)
+	for _, part := range []string{amzDate[:8], fake.region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	return hmac.Equal([]byte(hex.EncodeToString(key)), []byte(signature))
+}
+
+func (fake *fakeS3) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !fake.verify(request) {
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	switch request.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(request.Body)
+		fake.objects[request.URL.Path] = body
+	case http.MethodGet:
+		if body, exists := fake.objects[request.URL.Path]; exists {
+			writer.Write(body)
+		} else {
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	case http.MethodDelete:
+		delete(fake.objects, request.URL.Path)
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func testStore(t *testing.T, store BlobStore) {
+
+	data := []byte("some attachment data")
+	if err := store.Put("user/1/file.txt", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatal("Unexpected error putting blob: ", err)
+	}
+
+	reader, err := store.Get("user/1/file.txt")
+	if err != nil {
+		t.Fatal("Unexpected error getting blob: ", err)
+	}
+	stored, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(stored, data) {
+		t.Error("Expected stored data to match: ", string(stored))
+	}
+
+	if err := store.Delete("user/1/file.txt"); err != nil {
+		t.Error("Unexpected error deleting blob: ", err)
+	}
+
+	if _, err := store.Get("user/1/file.txt"); err != ErrNotFound {
+		t.Error("Expected deleted blob to be missing: ", err)
+	}
+
+}
+
+func TestLocalStore(t *testing.T) {
+	root, _ := ioutil.TempDir("", "blobstore")
+	defer os.RemoveAll(root)
+
+	store, err := NewLocalStore(root)
+	if err != nil {
+		t.Fatal("Unexpected error creating local store: ", err)
+	}
+	testStore(t, store)
+
+	if err := store.Put("../escape", bytes.NewReader(nil), 0, "text/plain"); err == nil {
+		t.Error("Expected keys outside the root to be refused")
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: make(map[string][]byte), accessKey: "access", secretKey: "secret", region: "us-east-1"})
+	defer server.Close()
+
+	store := NewS3Store(server.URL, "us-east-1", "attachments", "access", "secret")
+	testStore(t, store)
+
+	data := []byte("a key that needs escaping")
+	if err := store.Put("user/1/my file+1.txt", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Error("Expected escaped keys to be signed as sent: ", err)
+	}
+
+	wrongSecret := NewS3Store(server.URL, "us-east-1", "attachments", "access", "not the secret")
+	if err := wrongSecret.Put("user/1/file.txt", bytes.NewReader(data), int64(len(data)), "text/plain"); err == nil {
+		t.Error("Expected a request signed with the wrong secret to be refused")
+	}
+}
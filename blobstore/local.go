@@ -0,0 +1,78 @@
+package blobstore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Keeps blobs as files under a root directory
+type LocalStore struct {
+	Root string
+}
+
+// Creates a local store, making the root directory if it doesn't exist
+func NewLocalStore(root string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{Root: root}, nil
+}
+
+// Gets the file path for a key, refusing keys that would escape the root
+func (store *LocalStore) path(key string) (string, error) {
+	path := filepath.Join(store.Root, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(store.Root) + string(os.PathSeparator)) {
+		return "", ErrNotFound
+	}
+	return path, nil
+}
+
+func (store *LocalStore) Put(key string, reader io.Reader, size int64, contentType string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+func (store *LocalStore) Get(key string) (io.ReadCloser, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (store *LocalStore) Delete(key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package blobstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Keeps blobs in a bucket of an S3-compatible service, using path-style requests signed with AWS Signature V4
+type S3Store struct {
+	Endpoint string
+	Region string
+	Bucket string
+	AccessKey string
+	SecretKey string
+	Client *http.Client
+}
+
+// Creates an S3 store for the bucket at endpoint, e.g. https://s3.eu-west-1.amazonaws.com
+func NewS3Store(endpoint string, region string, bucket string, accessKey string, secretKey string) *S3Store {
+	return &S3Store{
+		Endpoint: strings.TrimRight(endpoint, "/"),
+		Region: region,
+		Bucket: bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+func (store *S3Store) Put(key string, reader io.Reader, size int64, contentType string) error {
+	request, err := store.newRequest(http.MethodPut, key, reader)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = size
+	request.Header.Set("Content-Type", contentType)
+
+	response, err := store.do(request)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (store *S3Store) Get(key string) (io.ReadCloser, error) {
+	request, err := store.newRequest(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := store.do(request)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+func (store *S3Store) Delete(key string) error {
+	request, err := store.newRequest(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := store.do(request)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (store *S3Store) newRequest(method string, key string, body io.Reader) (*http.Request, error) {
+	path := "/" + awsEscape(store.Bucket) + "/" + awsEscapePath(key)
+	request, err := http.NewRequest(method, store.Endpoint + path, body)
+	if err != nil {
+		return nil, err
+	}
+	request.URL.Opaque = path
+	return request, nil
+}
+
+// Signs and sends the request, turning error statuses into errors
+func (store *S3Store) do(request *http.Request) (*http.Response, error) {
+	store.sign(request, time.Now().UTC())
+
+	response, err := store.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, ErrNotFound
+	} else if response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+		return nil, fmt.Errorf("S3 request failed with status %d: %s", response.StatusCode, message)
+	}
+
+	return response, nil
+}
+
+// Adds the AWS Signature V4 headers, leaving the payload unsigned so bodies can be streamed
+func (store *S3Store) sign(request *http.Request, now time.Time) {
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + store.Region + "/s3/aws4_request"
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + request.URL.Host + "\n" +
+		"x-amz-content-sha256:" + unsignedPayload + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.Opaque,
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	signingKey := hmacSHA256([]byte("AWS4" + store.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, store.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		store.AccessKey, scope, signedHeaders, signature))
+
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Escapes each segment of a key, keeping the slashes between them
+func awsEscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Percent-encodes everything but the unreserved characters, as Signature V4 expects
+func awsEscape(value string) string {
+	var builder strings.Builder
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' {
+			builder.WriteByte(b)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
@@ -1,36 +1,154 @@
 package config
 
 import (
-	"fmt"
 	"github.com/spf13/viper"
+	"strings"
+	"time"
 )
 
+// Every setting can come from config.yaml, a FORUM_* environment variable or a command line flag,
+// the key is the yaml tag (nested sections join with "."), FORUM_ plus the upper cased key with "." replaced by "_" for
+// the environment and the key with "_" replaced by "-" for flags. Settings marked secret are redacted by --print-config
 type ConfigData struct {
-	User string `yaml:"user"`
-	Password string `yaml:"password"`
-	Database string `yaml:"database"`
-	TestDatabase string `yaml:"test_database"`
-	Secret string `yaml:"secret"`
+	User string `yaml:"user" usage:"Database user"`
+	Password string `yaml:"password" usage:"Database password" secret:"true"`
+	Database string `yaml:"database" usage:"Database name"`
+	TestDatabase string `yaml:"test_database" usage:"Database name used by the tests"`
+	Secret string `yaml:"secret" usage:"Key the session cookies are signed with" secret:"true"`
+	Host string `yaml:"host" usage:"Address to listen on, empty for all interfaces"`
+	Port int `yaml:"port" usage:"Port to listen on"`
+	TrustedProxies []string `yaml:"trusted_proxies" usage:"Proxy addresses or CIDRs whose X-Forwarded-For is believed, empty to use the connecting address"`
+	TLSCertFile string `yaml:"tls_cert_file" usage:"Certificate to serve HTTPS with, needs tls_key_file"`
+	TLSKeyFile string `yaml:"tls_key_file" usage:"Private key for tls_cert_file"`
+	HTTPReadTimeout time.Duration `yaml:"http_read_timeout" usage:"Longest time to read a whole request, 0 for no limit"`
+	HTTPReadHeaderTimeout time.Duration `yaml:"http_read_header_timeout" usage:"Longest time to read request headers"`
+	HTTPWriteTimeout time.Duration `yaml:"http_write_timeout" usage:"Longest time to write a response, 0 for no limit"`
+	HTTPIdleTimeout time.Duration `yaml:"http_idle_timeout" usage:"How long an idle keep-alive connection is kept open"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" usage:"How long to wait for requests in flight to finish when stopping"`
+	DBMaxOpenConns int `yaml:"db_max_open_conns" usage:"Most database connections open at once"`
+	DBMaxIdleConns int `yaml:"db_max_idle_conns" usage:"Most idle database connections kept in the pool"`
+	DBConnMaxLifetime time.Duration `yaml:"db_conn_max_lifetime" usage:"How long a database connection is reused for, like 10h"`
+	DBConnectAttempts int `yaml:"db_connect_attempts" usage:"Times to try reaching the database at startup, waiting longer after each"`
+	DBHost string `yaml:"db_host" usage:"Database host, empty for the driver default of localhost"`
+	DBPort int `yaml:"db_port" usage:"Database port"`
+	DBSocket string `yaml:"db_socket" usage:"Unix socket to reach the database through instead of db_host"`
+	DBTLS string `yaml:"db_tls" usage:"Database TLS: true, false, skip-verify or preferred"`
+	DBTLSCAFile string `yaml:"db_tls_ca_file" usage:"CA certificate to verify the database server with"`
+	DBTLSServerName string `yaml:"db_tls_server_name" usage:"Name on the database server certificate, defaults to db_host"`
+	DBConnectTimeout time.Duration `yaml:"db_connect_timeout" usage:"How long to wait connecting to the database, like 5s"`
+	DBReadTimeout time.Duration `yaml:"db_read_timeout" usage:"How long to wait reading from the database, 0 for no limit"`
+	DBWriteTimeout time.Duration `yaml:"db_write_timeout" usage:"How long to wait writing to the database, 0 for no limit"`
+	DBLocation string `yaml:"db_loc" usage:"Time zone database times are read in, like Local or UTC"`
+	DBCharset string `yaml:"db_charset" usage:"Connection character set"`
+	DBReplicaHost string `yaml:"db_replica_host" usage:"Read replica host, reads stay on the primary when no replica is set"`
+	DBReplicaPort int `yaml:"db_replica_port" usage:"Read replica port, defaults to db_port"`
+	DBReplicaSocket string `yaml:"db_replica_socket" usage:"Unix socket to reach the read replica through"`
+	DBReplicaUser string `yaml:"db_replica_user" usage:"Read replica user, defaults to user"`
+	DBReplicaPassword string `yaml:"db_replica_password" usage:"Read replica password, defaults to password" secret:"true"`
+	DBReplicaTLSServerName string `yaml:"db_replica_tls_server_name" usage:"Name on the read replica certificate, defaults to db_replica_host"`
+	DisableImages bool `yaml:"disable_images" usage:"Strip images from rendered markdown"`
+	Reactions []string `yaml:"reactions" usage:"Emoji allowed as reactions"`
+	ShowPollResultsBeforeVoting bool `yaml:"show_poll_results_before_voting" usage:"Show poll tallies to users who haven't voted"`
+	AttachmentStore string `yaml:"attachment_store" usage:"Where attachments are kept, local or s3"`
+	AttachmentDir string `yaml:"attachment_dir" usage:"Directory for the local attachment store"`
+	MaxAttachmentSize int64 `yaml:"max_attachment_size" usage:"Largest attachment in bytes"`
+	AttachmentQuota int64 `yaml:"attachment_quota" usage:"Attachment storage per user in bytes"`
+	S3Endpoint string `yaml:"s3_endpoint" usage:"S3 endpoint URL"`
+	S3Region string `yaml:"s3_region" usage:"S3 region"`
+	S3Bucket string `yaml:"s3_bucket" usage:"S3 bucket"`
+	S3AccessKey string `yaml:"s3_access_key" usage:"S3 access key" secret:"true"`
+	S3SecretKey string `yaml:"s3_secret_key" usage:"S3 secret key" secret:"true"`
+	DraftExpiryHours int `yaml:"draft_expiry_hours" usage:"Hours before an untouched draft is deleted"`
+	Admins []string `yaml:"admins" usage:"Usernames given the admin role at startup"`
+	DisableSpamFilter bool `yaml:"disable_spam_filter" usage:"Turn off spam scoring"`
+	SpamHoldScore float64 `yaml:"spam_hold_score" usage:"Spam score that holds content for moderation"`
+	SpamRejectScore float64 `yaml:"spam_reject_score" usage:"Spam score that rejects content"`
+	SpamBlockedDomains []string `yaml:"spam_blocked_domains" usage:"Link domains that mark content as spam"`
+	EnableTrustLevels bool `yaml:"enable_trust_levels" usage:"Restrict new accounts by trust level, only moderators can start threads until users reach level 1"`
+	PostRateLimit int `yaml:"post_rate_limit" usage:"Posts a new user can make per minute"`
+	AuditRetentionDays int `yaml:"audit_retention_days" usage:"Days audit log entries are kept for"`
+	Validation ValidationPolicy `yaml:"validation"`
 }
 
-// Loads config.yaml file with viper
-func LoadConfigWithViper() (*ConfigData, error) {
+// Limits on user input, read from the validation section, zero values keep the built in defaults
+type ValidationPolicy struct {
+	MinLengthTitle int `yaml:"min_length_title" usage:"Shortest thread title"`
+	MaxLengthTitle int `yaml:"max_length_title" usage:"Longest thread title"`
+	MinLengthContent int `yaml:"min_length_content" usage:"Shortest post content"`
+	MaxLengthContent int `yaml:"max_length_content" usage:"Longest post content"`
+	MinLengthUsername int `yaml:"min_length_username" usage:"Shortest username"`
+	MaxLengthUsername int `yaml:"max_length_username" usage:"Longest username"`
+	UsernamePattern string `yaml:"username_pattern" usage:"Regular expression usernames must match"`
+	ReservedUsernames []string `yaml:"reserved_usernames" usage:"Usernames nobody can register"`
+	MinLengthPassword int `yaml:"min_length_password" usage:"Shortest password"`
+	MaxLengthPassword int `yaml:"max_length_password" usage:"Longest password in bytes, at most 72"`
+	PasswordClasses []string `yaml:"password_classes" usage:"Character classes passwords need: lower, upper, digit, symbol"`
+	BreachedPasswordsFile string `yaml:"breached_passwords_file" usage:"File of breached passwords to reject, one per line"`
+	AllowUsernameInPassword bool `yaml:"allow_username_in_password" usage:"Allow passwords containing the username"`
+}
 
-	viper.SetConfigName("config")
-	viper.AddConfigPath("../")
-	viper.AddConfigPath(".")
-	viper.SetConfigType("yaml")
-	err := viper.ReadInConfig()
+const EnvPrefix = "FORUM"
+
+func setDefaults() {
+	viper.SetDefault("port", 8080)
+	viper.SetDefault("http_read_timeout", time.Second * 30)
+	viper.SetDefault("http_read_header_timeout", time.Second * 10)
+	viper.SetDefault("http_write_timeout", time.Minute)
+	viper.SetDefault("http_idle_timeout", time.Minute * 2)
+	viper.SetDefault("shutdown_timeout", time.Second * 30)
+	viper.SetDefault("db_max_open_conns", 20)
+	viper.SetDefault("db_max_idle_conns", 0)
+	viper.SetDefault("db_conn_max_lifetime", time.Hour * 10)
+	viper.SetDefault("db_connect_attempts", 10)
+	viper.SetDefault("db_port", 3306)
+	viper.SetDefault("db_loc", "Local")
+	viper.SetDefault("db_charset", "utf8")
+	viper.SetDefault("attachment_store", "local")
+	viper.SetDefault("attachment_dir", "attachments")
+}
 
+// Loads config.yaml file with viper and checks it's valid
+func LoadConfigWithViper() (*ConfigData, error) {
+	configData, err := ReadConfig()
 	if err != nil {
 		return nil, err
 	}
+	if err := configData.Validate(); err != nil {
+		return nil, err
+	}
+	return configData, nil
+}
 
-	configData := &ConfigData{User: viper.GetString("user"),
-		Password: viper.GetString("password"),
-		Database: viper.GetString("database"),
-		TestDatabase: viper.GetString("test_database"),
-		Secret: viper.GetString("secret")}
+// Reads the config without validating it, from config.yaml with the environment and flags (see ParseFlags) overriding it
+// The file is optional when everything is set some other way, unless a path was given with --config or FORUM_CONFIG
+func ReadConfig() (*ConfigData, error) {
+
+	setDefaults()
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	viper.SetConfigType("yaml")
+
+	if path := viper.GetString("config"); path != "" {
+		viper.SetConfigFile(path)
+		if err := viper.ReadInConfig(); err != nil {
+			return nil, err
+		}
+	} else {
+		viper.SetConfigName("config")
+		viper.AddConfigPath("../")
+		viper.AddConfigPath(".")
+		if err := viper.ReadInConfig(); err != nil {
+			if _, notFound := err.(viper.ConfigFileNotFoundError); !notFound {
+				return nil, err
+			}
+		}
+	}
+
+	configData := new (ConfigData)
+	if err := decode(configData); err != nil {
+		return nil, err
+	}
 	return configData, nil
 
 }
@@ -40,10 +158,64 @@ func GetConnectionString(test bool) (string, error) {
 	if dbConfig, err := LoadConfigWithViper(); err != nil {
 		return "", err
 	} else {
-		if test {
-			return fmt.Sprintf("%s:%s@/%s?charset=utf8&parseTime=True&loc=Local", dbConfig.User, dbConfig.Password, dbConfig.TestDatabase), nil
-		} else {
-			return fmt.Sprintf("%s:%s@/%s?charset=utf8&parseTime=True&loc=Local", dbConfig.User, dbConfig.Password, dbConfig.Database), nil
-		}
+		return dbConfig.ConnectionString(test)
+	}
+}
+
+// The options for the primary database, or the test database when test is set
+func (configData *ConfigData) DSNOptions(test bool) *DSNOptions {
+	database := configData.Database
+	if test {
+		database = configData.TestDatabase
+	}
+	return &DSNOptions{
+		User: configData.User,
+		Password: configData.Password,
+		Database: database,
+		Host: configData.DBHost,
+		Port: configData.DBPort,
+		Socket: configData.DBSocket,
+		TLS: configData.DBTLS,
+		TLSCAFile: configData.DBTLSCAFile,
+		TLSServerName: configData.DBTLSServerName,
+		ConnectTimeout: configData.DBConnectTimeout,
+		ReadTimeout: configData.DBReadTimeout,
+		WriteTimeout: configData.DBWriteTimeout,
+		Location: configData.DBLocation,
+		Charset: configData.DBCharset,
+	}
+}
+
+// The options for the read replica, anything not set for the replica is shared with the primary. Nil without a replica
+// The certificate name isn't shared, the replica is a different server
+func (configData *ConfigData) ReplicaDSNOptions(test bool) *DSNOptions {
+	if configData.DBReplicaHost == "" && configData.DBReplicaSocket == "" {
+		return nil
 	}
-}
\ No newline at end of file
+	options := configData.DSNOptions(test)
+	options.Host = configData.DBReplicaHost
+	options.Socket = configData.DBReplicaSocket
+	options.TLSServerName = configData.DBReplicaTLSServerName
+	if configData.DBReplicaPort != 0 {
+		options.Port = configData.DBReplicaPort
+	}
+	if configData.DBReplicaUser != "" {
+		options.User = configData.DBReplicaUser
+	}
+	if configData.DBReplicaPassword != "" {
+		options.Password = configData.DBReplicaPassword
+	}
+	return options
+}
+
+func (configData *ConfigData) ConnectionString(test bool) (string, error) {
+	return configData.DSNOptions(test).FormatDSN()
+}
+
+// The DSN of the read replica, empty when there isn't one
+func (configData *ConfigData) ReplicaConnectionString(test bool) (string, error) {
+	if options := configData.ReplicaDSNOptions(test); options != nil {
+		return options.FormatDSN()
+	}
+	return "", nil
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"bytes"
 	"testing"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 )
 
 func TestGetConnectionString(t *testing.T) {
@@ -15,4 +19,63 @@ func TestGetConnectionString(t *testing.T) {
 
 func TestLoadConfigWithViper(t *testing.T) {
 	LoadConfigWithViper()
-}
\ No newline at end of file
+}
+func TestEnvironmentOverrides(t *testing.T) {
+
+	os.Setenv("FORUM_POST_RATE_LIMIT", "5")
+	os.Setenv("FORUM_VALIDATION_RESERVED_USERNAMES", "staff, owner")
+	defer os.Unsetenv("FORUM_POST_RATE_LIMIT")
+	defer os.Unsetenv("FORUM_VALIDATION_RESERVED_USERNAMES")
+
+	configData, err := ReadConfig()
+	if err != nil {
+		t.Error("Unexpected error reading config: ", err)
+	} else if configData.PostRateLimit != 5 || len(configData.Validation.ReservedUsernames) != 2 {
+		t.Error("Expected the environment to override the config file, got ", configData.PostRateLimit, configData.Validation.ReservedUsernames)
+	}
+
+	os.Setenv("FORUM_DB_MAX_OPEN_CONNS", "lots")
+	os.Setenv("FORUM_NOT_A_SETTING", "1")
+	defer os.Unsetenv("FORUM_DB_MAX_OPEN_CONNS")
+	defer os.Unsetenv("FORUM_NOT_A_SETTING")
+
+	if _, err := ReadConfig(); err == nil || !strings.Contains(err.Error(), "db_max_open_conns") || !strings.Contains(err.Error(), "FORUM_NOT_A_SETTING") {
+		t.Error("Expected unparseable values and unknown variables to be rejected, got ", err)
+	}
+
+}
+
+func TestValidate(t *testing.T) {
+
+	configData := &ConfigData{User: "forum", Database: "forum", Secret: "secret", Port: 0, TLSCertFile: "cert.pem",
+		AttachmentStore: "local", DBMaxOpenConns: 5, DBMaxIdleConns: 10, DBPort: 3306, DBHost: "db.example.com", DBSocket: "/tmp/mysql.sock",
+		DBTLS: "always", DBLocation: "Mars/Olympus_Mons"}
+
+	err := configData.Validate()
+	if err == nil {
+		t.Error("Expected an invalid config to be rejected")
+		return
+	}
+	for _, key := range []string{"port", "tls_key_file", "db_max_idle_conns", "db_socket", "db_tls", "db_loc"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Error("Expected the problem with " + key + " to be reported, got ", err)
+		}
+	}
+
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+
+	var output bytes.Buffer
+	configData := &ConfigData{User: "forum", Password: "hunter22", DBConnMaxLifetime: time.Hour}
+	Print(&output, configData)
+
+	printed := output.String()
+	if strings.Contains(printed, "hunter22") || !strings.Contains(printed, "[redacted]") {
+		t.Error("Expected the password to be redacted, got ", printed)
+	}
+	if !strings.Contains(printed, "\"1h0m0s\"") || !strings.Contains(printed, "\"validation\"") {
+		t.Error("Expected durations and sections to be printed, got ", printed)
+	}
+
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+)
+
+var tlsModes = []string{"", "false", "true", "skip-verify", "preferred"}
+
+// Everything needed to reach one MySQL server
+type DSNOptions struct {
+	User string
+	Password string
+	Database string
+	// Host and Port connect over TCP, Socket over a unix socket, with neither the driver uses localhost:3306
+	Host string
+	Port int
+	Socket string
+	// TLS is one of tlsModes, a TLSCAFile verifies the server against that CA instead of the system roots
+	TLS string
+	TLSCAFile string
+	TLSServerName string
+	ConnectTimeout time.Duration
+	ReadTimeout time.Duration
+	WriteTimeout time.Duration
+	// Location is the time zone DATETIME values are read in, like Local, UTC or Europe/London
+	Location string
+	Charset string
+}
+
+// Builds the driver DSN, leaving the driver to handle the escaping of passwords with special characters
+func (options *DSNOptions) FormatDSN() (string, error) {
+
+	cfg := mysql.NewConfig()
+	cfg.User = options.User
+	cfg.Passwd = options.Password
+	cfg.DBName = options.Database
+	cfg.ParseTime = true
+	cfg.Timeout = options.ConnectTimeout
+	cfg.ReadTimeout = options.ReadTimeout
+	cfg.WriteTimeout = options.WriteTimeout
+
+	if options.Socket != "" && options.Host != "" {
+		return "", fmt.Errorf("dsn: set either a host or a socket, not both")
+	} else if options.Socket != "" {
+		cfg.Net = "unix"
+		cfg.Addr = options.Socket
+	} else if options.Host != "" {
+		port := options.Port
+		if port == 0 {
+			port = 3306
+		}
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(options.Host, strconv.Itoa(port))
+	}
+
+	if options.Charset != "" {
+		cfg.Params = map[string]string{"charset": options.Charset}
+	}
+
+	if options.Location != "" {
+		location, err := time.LoadLocation(options.Location)
+		if err != nil {
+			return "", fmt.Errorf("dsn: unknown location %q", options.Location)
+		}
+		cfg.Loc = location
+	}
+
+	tlsName, err := options.registerTLS()
+	if err != nil {
+		return "", err
+	}
+	cfg.TLSConfig = tlsName
+
+	return cfg.FormatDSN(), nil
+
+}
+
+// Works out the driver's TLS setting, registering a config with the driver when a CA file is given
+func (options *DSNOptions) registerTLS() (string, error) {
+
+	if !stringInList(tlsModes, options.TLS) {
+		return "", fmt.Errorf("dsn: tls must be one of true, false, skip-verify or preferred, got %q", options.TLS)
+	}
+
+	if options.TLSCAFile == "" {
+		return options.TLS, nil
+	}
+	if options.TLS == "false" || options.TLS == "skip-verify" {
+		return "", fmt.Errorf("dsn: a tls ca file can't be used with tls %s", options.TLS)
+	}
+
+	pem, err := ioutil.ReadFile(options.TLSCAFile)
+	if err != nil {
+		return "", fmt.Errorf("dsn: can't read tls ca file: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return "", fmt.Errorf("dsn: no certificates found in %s", options.TLSCAFile)
+	}
+
+	serverName := options.TLSServerName
+	if serverName == "" {
+		serverName = options.Host
+	}
+
+	// Named after what it verifies so the primary and replica can share one registration
+	name := "forum-" + options.TLSCAFile + "-" + serverName
+	if err := mysql.RegisterTLSConfig(name, &tls.Config{RootCAs: roots, ServerName: serverName}); err != nil {
+		return "", err
+	}
+	return name, nil
+
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/go-sql-driver/mysql"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes a self signed CA certificate to a temporary directory
+func writeTestCA(t *testing.T) string {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "forum test ca"},
+		NotBefore: time.Now(),
+		NotAfter: time.Now().Add(time.Hour),
+		IsCA: true,
+		BasicConstraintsValid: true,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory, err := ioutil.TempDir("", "forum-dsn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(directory, "ca.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+
+}
+
+func TestFormatDSN(t *testing.T) {
+
+	caFile := writeTestCA(t)
+	defer os.RemoveAll(filepath.Dir(caFile))
+
+	london, _ := time.LoadLocation("Europe/London")
+
+	tests := []struct {
+		name string
+		options DSNOptions
+		check func(*mysql.Config) bool
+	}{
+		{"default", DSNOptions{User: "forum", Password: "secret", Database: "forum"}, func(cfg *mysql.Config) bool {
+			return cfg.Net == "tcp" && cfg.Addr == "127.0.0.1:3306" && cfg.User == "forum" && cfg.Passwd == "secret" &&
+				cfg.DBName == "forum" && cfg.ParseTime && cfg.Loc == time.UTC && cfg.TLSConfig == ""
+		}},
+		{"host", DSNOptions{Database: "forum", Host: "db.example.com"}, func(cfg *mysql.Config) bool {
+			return cfg.Net == "tcp" && cfg.Addr == "db.example.com:3306"
+		}},
+		{"host and port", DSNOptions{Database: "forum", Host: "db.example.com", Port: 3307}, func(cfg *mysql.Config) bool {
+			return cfg.Addr == "db.example.com:3307"
+		}},
+		{"ipv6", DSNOptions{Database: "forum", Host: "::1", Port: 3307}, func(cfg *mysql.Config) bool {
+			return cfg.Addr == "[::1]:3307"
+		}},
+		{"socket", DSNOptions{Database: "forum", Socket: "/run/mysqld/mysqld.sock"}, func(cfg *mysql.Config) bool {
+			return cfg.Net == "unix" && cfg.Addr == "/run/mysqld/mysqld.sock"
+		}},
+		{"tls", DSNOptions{Database: "forum", Host: "db.example.com", TLS: "true"}, func(cfg *mysql.Config) bool {
+			return cfg.TLSConfig == "true"
+		}},
+		{"tls skip verify", DSNOptions{Database: "forum", Host: "db.example.com", TLS: "skip-verify"}, func(cfg *mysql.Config) bool {
+			return cfg.TLSConfig == "skip-verify"
+		}},
+		{"tls preferred", DSNOptions{Database: "forum", Host: "db.example.com", TLS: "preferred"}, func(cfg *mysql.Config) bool {
+			return cfg.TLSConfig == "preferred"
+		}},
+		{"tls ca", DSNOptions{Database: "forum", Host: "db.example.com", TLS: "true", TLSCAFile: caFile}, func(cfg *mysql.Config) bool {
+			return strings.HasPrefix(cfg.TLSConfig, "forum-") && strings.HasSuffix(cfg.TLSConfig, "-db.example.com")
+		}},
+		{"tls ca with server name", DSNOptions{Database: "forum", Host: "10.0.0.5", TLSCAFile: caFile, TLSServerName: "db.internal"}, func(cfg *mysql.Config) bool {
+			return strings.HasSuffix(cfg.TLSConfig, "-db.internal")
+		}},
+		{"timeouts", DSNOptions{Database: "forum", ConnectTimeout: 5 * time.Second, ReadTimeout: 30 * time.Second, WriteTimeout: time.Minute}, func(cfg *mysql.Config) bool {
+			return cfg.Timeout == 5 * time.Second && cfg.ReadTimeout == 30 * time.Second && cfg.WriteTimeout == time.Minute
+		}},
+		{"location", DSNOptions{Database: "forum", Location: "Europe/London"}, func(cfg *mysql.Config) bool {
+			return cfg.Loc.String() == london.String()
+		}},
+		{"local location", DSNOptions{Database: "forum", Location: "Local"}, func(cfg *mysql.Config) bool {
+			return cfg.Loc == time.Local
+		}},
+		{"charset", DSNOptions{Database: "forum", Charset: "utf8mb4"}, func(cfg *mysql.Config) bool {
+			return cfg.Params["charset"] == "utf8mb4"
+		}},
+		{"special characters", DSNOptions{User: "forum", Password: "p@ss:w/rd?&=%#", Database: "forum", Host: "db.example.com"}, func(cfg *mysql.Config) bool {
+			return cfg.User == "forum" && cfg.Passwd == "p@ss:w/rd?&=%#" && cfg.Addr == "db.example.com:3306" && cfg.DBName == "forum"
+		}},
+		{"special characters over a socket", DSNOptions{User: "forum", Password: "@/)(", Database: "forum", Socket: "/tmp/mysql.sock"}, func(cfg *mysql.Config) bool {
+			return cfg.Passwd == "@/)(" && cfg.Addr == "/tmp/mysql.sock" && cfg.DBName == "forum"
+		}},
+	}
+
+	for _, test := range tests {
+		dsn, err := test.options.FormatDSN()
+		if err != nil {
+			t.Error(test.name, ": unexpected error formatting DSN: ", err)
+			continue
+		}
+		if cfg, err := mysql.ParseDSN(dsn); err != nil {
+			t.Error(test.name, ": the driver couldn't parse ", dsn, ": ", err)
+		} else if !test.check(cfg) {
+			t.Error(test.name, ": unexpected DSN ", dsn)
+		}
+	}
+
+}
+
+func TestFormatDSNInvalid(t *testing.T) {
+
+	tests := []struct {
+		name string
+		options DSNOptions
+	}{
+		{"host and socket", DSNOptions{Host: "db.example.com", Socket: "/tmp/mysql.sock"}},
+		{"unknown tls mode", DSNOptions{TLS: "always"}},
+		{"ca without verification", DSNOptions{TLS: "skip-verify", TLSCAFile: "ca.pem"}},
+		{"missing ca", DSNOptions{TLS: "true", TLSCAFile: "missing.pem"}},
+		{"unknown location", DSNOptions{Location: "Mars/Olympus_Mons"}},
+	}
+
+	for _, test := range tests {
+		if dsn, err := test.options.FormatDSN(); err == nil {
+			t.Error(test.name, ": expected an error, got ", dsn)
+		}
+	}
+
+}
+
+func TestReplicaConnectionString(t *testing.T) {
+
+	configData := &ConfigData{User: "forum", Password: "secret", Database: "forum", TestDatabase: "forum_test",
+		DBHost: "primary.example.com", DBPort: 3306, DBLocation: "UTC", DBCharset: "utf8mb4", DBReadTimeout: time.Second}
+
+	if dsn, err := configData.ReplicaConnectionString(false); err != nil || dsn != "" {
+		t.Error("Expected no replica DSN without a replica, got ", dsn, err)
+	}
+
+	configData.DBReplicaHost = "replica.example.com"
+	dsn, err := configData.ReplicaConnectionString(true)
+	if err != nil {
+		t.Fatal("Unexpected error formatting replica DSN: ", err)
+	}
+	cfg, _ := mysql.ParseDSN(dsn)
+	if cfg.Addr != "replica.example.com:3306" || cfg.User != "forum" || cfg.Passwd != "secret" || cfg.DBName != "forum_test" ||
+		cfg.Params["charset"] != "utf8mb4" || cfg.ReadTimeout != time.Second {
+		t.Error("Expected the replica to share the primary's settings, got ", dsn)
+	}
+
+	configData.DBReplicaUser = "reader"
+	dsn, _ = configData.ReplicaConnectionString(false)
+	cfg, _ = mysql.ParseDSN(dsn)
+	if cfg.User != "reader" || cfg.Passwd != "secret" {
+		t.Error("Expected the replica user to fall back to the primary's password, got ", dsn)
+	}
+
+	configData.DBReplicaUser = ""
+	configData.DBReplicaPassword = "r3@d"
+	dsn, _ = configData.ReplicaConnectionString(false)
+	cfg, _ = mysql.ParseDSN(dsn)
+	if cfg.User != "forum" || cfg.Passwd != "r3@d" {
+		t.Error("Expected the replica password to be used with the primary's user, got ", dsn)
+	}
+
+	configData.DBReplicaPort = 3307
+	configData.DBReplicaUser = "reader"
+	dsn, _ = configData.ReplicaConnectionString(false)
+	cfg, _ = mysql.ParseDSN(dsn)
+	if cfg.Addr != "replica.example.com:3307" || cfg.User != "reader" || cfg.Passwd != "r3@d" || cfg.DBName != "forum" {
+		t.Error("Expected the replica's own port and credentials, got ", dsn)
+	}
+
+	configData.DBTLSServerName = "primary.internal"
+	if options := configData.ReplicaDSNOptions(false); options.TLSServerName != "" {
+		t.Error("Expected the replica not to verify against the primary's certificate name, got ", options.TLSServerName)
+	}
+	configData.DBReplicaTLSServerName = "replica.internal"
+	if options := configData.ReplicaDSNOptions(false); options.TLSServerName != "replica.internal" {
+		t.Error("Expected the replica's own certificate name, got ", options.TLSServerName)
+	}
+
+	configData.DBReplicaHost = ""
+	configData.DBReplicaSocket = "/run/mysqld/replica.sock"
+	dsn, _ = configData.ReplicaConnectionString(false)
+	cfg, _ = mysql.ParseDSN(dsn)
+	if cfg.Net != "unix" || cfg.Addr != "/run/mysqld/replica.sock" {
+		t.Error("Expected the replica socket, got ", dsn)
+	}
+
+	primary, _ := configData.ConnectionString(false)
+	cfg, _ = mysql.ParseDSN(primary)
+	if cfg.Addr != "primary.example.com:3306" || cfg.User != "forum" {
+		t.Error("Expected the primary to be unchanged by the replica settings, got ", primary)
+	}
+
+}
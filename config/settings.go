@@ -0,0 +1,321 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const redacted = "[redacted]"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// A single setting found by walking the yaml tags of ConfigData
+type setting struct {
+	key string
+	usage string
+	secret bool
+	value reflect.Value
+}
+
+// Finds every setting in a config struct, nested structs become sections of dotted keys
+func settings(value reflect.Value, prefix string) []setting {
+	var found []setting
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := prefix + field.Tag.Get("yaml")
+		if field.Type.Kind() == reflect.Struct {
+			found = append(found, settings(value.Field(i), key + ".")...)
+			continue
+		}
+		found = append(found, setting{key: key, usage: field.Tag.Get("usage"), secret: field.Tag.Get("secret") == "true", value: value.Field(i)})
+	}
+	return found
+}
+
+// The environment variable that sets a key, like FORUM_VALIDATION_MIN_LENGTH_TITLE for validation.min_length_title
+func envName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// The command line flag that sets a key, like --validation.min-length-title for validation.min_length_title
+func flagName(key string) string {
+	return strings.Replace(key, "_", "-", -1)
+}
+
+// Lists can be given as yaml lists or, from the environment and flags, separated by commas or spaces
+func splitList(text string) []string {
+	return strings.FieldsFunc(text, func(char rune) bool {
+		return char == ',' || unicode.IsSpace(char)
+	})
+}
+
+// Sets a setting from whatever viper found for it, failing on anything that doesn't parse rather than using a zero value
+func (s setting) set(raw interface{}) error {
+
+	if s.value.Kind() == reflect.Slice {
+		var list []string
+		switch typed := raw.(type) {
+		case string:
+			list = splitList(typed)
+		case []string:
+			list = typed
+		case []interface{}:
+			for _, item := range typed {
+				list = append(list, fmt.Sprint(item))
+			}
+		default:
+			return fmt.Errorf("%s: expected a list, got %v", s.key, raw)
+		}
+		s.value.Set(reflect.ValueOf(list))
+		return nil
+	}
+
+	text := strings.TrimSpace(fmt.Sprint(raw))
+	if s.value.Type() == durationType {
+		duration, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("%s: expected a duration like 10h or 30m, got %q", s.key, text)
+		}
+		s.value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch s.value.Kind() {
+	case reflect.String:
+		s.value.SetString(fmt.Sprint(raw))
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%s: expected true or false, got %q", s.key, text)
+		}
+		s.value.SetBool(parsed)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: expected a whole number, got %q", s.key, text)
+		}
+		s.value.SetInt(parsed)
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("%s: expected a number, got %q", s.key, text)
+		}
+		s.value.SetFloat(parsed)
+	}
+	return nil
+
+}
+
+// Fills in configData from viper, rejecting unknown keys in the config file and unknown FORUM_* variables
+func decode(configData *ConfigData) error {
+
+	var problems []string
+	known := map[string]bool{"config": true}
+
+	for _, s := range settings(reflect.ValueOf(configData).Elem(), "") {
+		known[s.key] = true
+		if index := strings.LastIndex(s.key, "."); index > 0 {
+			known[s.key[:index]] = true
+		}
+		if raw := viper.Get(s.key); raw != nil {
+			if err := s.set(raw); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+
+	knownEnv := make(map[string]bool)
+	for key := range known {
+		knownEnv[envName(key)] = true
+	}
+
+	for _, key := range viper.AllKeys() {
+		if !known[key] {
+			problems = append(problems, fmt.Sprintf("%s: unknown setting", key))
+		}
+	}
+	for _, variable := range os.Environ() {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if strings.HasPrefix(name, EnvPrefix + "_") && !knownEnv[name] {
+			problems = append(problems, fmt.Sprintf("%s: unknown environment variable", name))
+		}
+	}
+
+	return configError(problems)
+
+}
+
+func stringInList(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func configError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// Registers a flag for every setting, plus --config and --print-config, and parses args (without the program name)
+// Flags override the environment, which overrides the config file. Returns whether --print-config was given
+func ParseFlags(args []string) (bool, error) {
+
+	flags := pflag.NewFlagSet("forum", pflag.ContinueOnError)
+	flags.String("config", "", "Config file to read instead of looking for config.yaml in . and ../")
+	printConfig := flags.Bool("print-config", false, "Print the config with secrets redacted and exit")
+
+	var keys []string
+	for _, s := range settings(reflect.ValueOf(&ConfigData{}).Elem(), "") {
+		if s.value.Kind() == reflect.Bool {
+			flags.Bool(flagName(s.key), false, s.usage)
+		} else {
+			flags.String(flagName(s.key), "", s.usage)
+		}
+		keys = append(keys, s.key)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return false, err
+	}
+
+	// Only flags that were given are bound, so an unset flag never hides a value from the file or environment
+	for _, key := range append(keys, "config") {
+		if flag := flags.Lookup(flagName(key)); flag != nil && flag.Changed {
+			viper.Set(key, flag.Value.String())
+		}
+	}
+	return *printConfig, nil
+
+}
+
+// Writes the config as JSON (which config.yaml can also be written in) with the secrets redacted
+func Print(writer io.Writer, configData *ConfigData) error {
+
+	output := make(map[string]interface{})
+	for _, s := range settings(reflect.ValueOf(configData).Elem(), "") {
+		var value interface{} = s.value.Interface()
+		if s.value.Type() == durationType {
+			value = s.value.Interface().(time.Duration).String()
+		} else if s.secret && s.value.String() != "" {
+			value = redacted
+		}
+
+		section := output
+		parts := strings.Split(s.key, ".")
+		for _, part := range parts[:len(parts) - 1] {
+			if _, exists := section[part]; !exists {
+				section[part] = make(map[string]interface{})
+			}
+			section = section[part].(map[string]interface{})
+		}
+		section[parts[len(parts) - 1]] = value
+	}
+
+	encoded, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(writer, string(encoded))
+	return err
+
+}
+
+// Checks the settings make sense together, reporting every problem at once
+func (configData *ConfigData) Validate() error {
+
+	var problems []string
+	require := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	fileExists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	require(configData.User != "", "user: required")
+	require(configData.Database != "" || configData.TestDatabase != "", "database: required")
+	require(configData.Secret != "", "secret: required")
+	require(configData.Port > 0 && configData.Port <= 65535, "port: must be between 1 and 65535")
+
+	require((configData.TLSCertFile == "") == (configData.TLSKeyFile == ""), "tls_cert_file, tls_key_file: set both or neither")
+	if configData.TLSCertFile != "" {
+		require(fileExists(configData.TLSCertFile), "tls_cert_file: %s doesn't exist", configData.TLSCertFile)
+	}
+	if configData.TLSKeyFile != "" {
+		require(fileExists(configData.TLSKeyFile), "tls_key_file: %s doesn't exist", configData.TLSKeyFile)
+	}
+
+	require(configData.HTTPReadTimeout >= 0, "http_read_timeout: can't be negative")
+	require(configData.HTTPReadHeaderTimeout >= 0, "http_read_header_timeout: can't be negative")
+	require(configData.HTTPWriteTimeout >= 0, "http_write_timeout: can't be negative")
+	require(configData.HTTPIdleTimeout >= 0, "http_idle_timeout: can't be negative")
+	require(configData.ShutdownTimeout > 0, "shutdown_timeout: must be more than 0")
+
+	require(configData.DBMaxOpenConns >= 0, "db_max_open_conns: can't be negative, 0 means unlimited")
+	require(configData.DBMaxIdleConns >= 0, "db_max_idle_conns: can't be negative")
+	require(configData.DBMaxOpenConns == 0 || configData.DBMaxIdleConns <= configData.DBMaxOpenConns,
+		"db_max_idle_conns: can't be more than db_max_open_conns")
+	require(configData.DBConnMaxLifetime >= 0, "db_conn_max_lifetime: can't be negative")
+	require(configData.DBConnectAttempts > 0, "db_connect_attempts: must be at least 1")
+
+	require(configData.DBPort > 0 && configData.DBPort <= 65535, "db_port: must be between 1 and 65535")
+	require(configData.DBReplicaPort >= 0 && configData.DBReplicaPort <= 65535, "db_replica_port: must be between 0 and 65535, 0 uses db_port")
+	require(configData.DBHost == "" || configData.DBSocket == "", "db_host, db_socket: set one or the other")
+	require(configData.DBReplicaHost == "" || configData.DBReplicaSocket == "", "db_replica_host, db_replica_socket: set one or the other")
+	if configData.DBSocket != "" {
+		require(fileExists(configData.DBSocket), "db_socket: %s doesn't exist", configData.DBSocket)
+	}
+	if configData.DBReplicaSocket != "" {
+		require(fileExists(configData.DBReplicaSocket), "db_replica_socket: %s doesn't exist", configData.DBReplicaSocket)
+	}
+	require(stringInList(tlsModes, configData.DBTLS), "db_tls: must be true, false, skip-verify or preferred")
+	if configData.DBTLSCAFile != "" {
+		require(fileExists(configData.DBTLSCAFile), "db_tls_ca_file: %s doesn't exist", configData.DBTLSCAFile)
+		require(configData.DBTLS != "false" && configData.DBTLS != "skip-verify", "db_tls_ca_file: can't be used with db_tls %s", configData.DBTLS)
+	}
+	require(configData.DBConnectTimeout >= 0, "db_connect_timeout: can't be negative")
+	require(configData.DBReadTimeout >= 0, "db_read_timeout: can't be negative")
+	require(configData.DBWriteTimeout >= 0, "db_write_timeout: can't be negative")
+	if configData.DBLocation != "" {
+		_, err := time.LoadLocation(configData.DBLocation)
+		require(err == nil, "db_loc: unknown time zone %q", configData.DBLocation)
+	}
+
+	require(configData.AttachmentStore == "local" || configData.AttachmentStore == "s3", "attachment_store: must be local or s3")
+	if configData.AttachmentStore == "s3" {
+		require(configData.S3Bucket != "", "s3_bucket: required when attachment_store is s3")
+		require(configData.S3Region != "", "s3_region: required when attachment_store is s3")
+	}
+	require(configData.MaxAttachmentSize >= 0, "max_attachment_size: can't be negative")
+	require(configData.AttachmentQuota >= 0, "attachment_quota: can't be negative")
+	require(configData.DraftExpiryHours >= 0, "draft_expiry_hours: can't be negative")
+	require(configData.PostRateLimit >= 0, "post_rate_limit: can't be negative")
+	require(configData.AuditRetentionDays >= 0, "audit_retention_days: can't be negative")
+
+	require(configData.SpamHoldScore >= 0, "spam_hold_score: can't be negative")
+	require(configData.SpamRejectScore >= 0, "spam_reject_score: can't be negative")
+	if configData.SpamHoldScore > 0 && configData.SpamRejectScore > 0 {
+		require(configData.SpamHoldScore < configData.SpamRejectScore, "spam_hold_score: must be less than spam_reject_score")
+	}
+
+	return configError(problems)
+
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"bytes"
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/blobstore"
+	"ForumDatabase/errors"
+	"ForumDatabase/helpers"
+	"github.com/twinj/uuid"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"fmt"
+)
+
+var (
+	MaxAttachmentSize int64 = 5 * 1024 * 1024
+	AttachmentQuota int64 = 100 * 1024 * 1024
+	AllowedAttachmentTypes = []string{"image/png", "image/jpeg", "image/gif", "application/pdf", "text/plain"}
+)
+
+type Attachment struct {
+	BaseModel
+	UserID uint `json:"-"`
+	ThreadID uint `json:"threadId"`
+	PostID uint `json:"postId"`
+	Key string `json:"-"`
+	Filename string `json:"filename"`
+	MimeType string `json:"mimeType"`
+	Size int64 `json:"size"`
+	Width int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Counts the bytes of attachments the user has uploaded
+func GetAttachmentUsage(db *gorm.DB, user *User) int64 {
+	var usage int64
+	db.Model(&Attachment{}).Where("user_id = ?", user.ID).Select("COALESCE(SUM(size), 0)").Row().Scan(&usage)
+	return usage
+}
+
+// Stores a file for one of the user's threads or posts (exactly one of threadId/postId should be set)
+// The type is sniffed from the data rather than trusted from the upload, and images get their dimensions recorded
+func CreateAttachment(db *gorm.DB, store blobstore.BlobStore, user *User, threadId uint, postId uint, filename string, reader io.Reader) (*Attachment, *errors.UserError) {
+
+	if (threadId > 0) == (postId > 0) {
+		return nil, errors.ErrBadRecord
+	}
+
+	if err := helpers.ValidateFilename(filename); err != nil {
+		return nil, err
+	}
+
+	if !canAttach(db, user) {
+		return nil, errors.ErrTrustLevel
+	}
+
+	if threadId > 0 {
+		if _, err := FindUserThread(db, user, threadId); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := FindUserPost(db, user, postId); err != nil {
+			return nil, err
+		}
+	}
+
+	data, readErr := ioutil.ReadAll(io.LimitReader(reader, MaxAttachmentSize + 1))
+	if readErr != nil {
+		return nil, errors.ErrSystem
+	}
+
+	size := int64(len(data))
+	if size == 0 {
+		return nil, errors.ErrTooShort
+	} else if size > MaxAttachmentSize {
+		return nil, errors.ErrTooLarge
+	}
+
+	if GetAttachmentUsage(db, user) + size > AttachmentQuota {
+		return nil, errors.ErrQuotaExceeded
+	}
+
+	mimeType := strings.Split(http.DetectContentType(data), ";")[0]
+	if !helpers.StringInSlice(AllowedAttachmentTypes, mimeType) {
+		return nil, errors.ErrUnsupportedType
+	}
+
+	attachment := Attachment{UserID: user.ID, ThreadID: threadId, PostID: postId, Filename: filename,
+		MimeType: mimeType, Size: size, Timestamp: MakeTimestamp()}
+	attachment.Key = fmt.Sprintf("%d/%s", user.ID, uuid.NewV4().String())
+
+	if strings.HasPrefix(mimeType, "image/") {
+		if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			attachment.Width = config.Width
+			attachment.Height = config.Height
+		}
+	}
+
+	if err := store.Put(attachment.Key, bytes.NewReader(data), size, mimeType); err != nil {
+		return nil, errors.ErrSystem
+	}
+
+	if err := db.Create(&attachment).Error; err != nil {
+		store.Delete(attachment.Key)
+		return nil, errors.ErrSystem
+	}
+
+	return &attachment, nil
+
+}
+
+// Finds an attachment by id, but only if the thread or post it belongs to hasn't been deleted and the viewer can see it
+func FindAttachment(db *gorm.DB, viewer *User, id uint) (*Attachment, *errors.UserError) {
+
+	var attachment Attachment
+	db.First(&attachment, id)
+	if attachment.ID < 1 {
+		return nil, errors.ErrNotExist
+	}
+
+	if attachment.PostID > 0 {
+		if _, err := FindVisiblePost(db, viewer, attachment.PostID); err != nil {
+			return nil, err
+		}
+	} else if _, err := FindVisibleThread(db, viewer, attachment.ThreadID); err != nil {
+		return nil, err
+	}
+
+	return &attachment, nil
+
+}
+
+// Loads the attachments for posts and any nested replies
+func LoadPostAttachments(db *gorm.DB, posts []Post) {
+
+	var ids []uint
+	var collect func(posts []Post)
+	collect = func(posts []Post) {
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+			collect(post.Replies)
+		}
+	}
+	collect(posts)
+
+	if len(ids) == 0 {
+		return
+	}
+
+	var attachments []Attachment
+	db.Where("post_id IN (?)", ids).Order("timestamp").Find(&attachments)
+
+	var fill func(posts []Post)
+	fill = func(posts []Post) {
+		for i := range posts {
+			for _, attachment := range attachments {
+				if attachment.PostID == posts[i].ID {
+					posts[i].Attachments = append(posts[i].Attachments, attachment)
+				}
+			}
+			fill(posts[i].Replies)
+		}
+	}
+	fill(posts)
+
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"encoding/json"
+	goerrors "errors"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+const (
+	AuditIPKey = "audit:ip"
+	auditPurgeKey = "audit:purge"
+
+	TargetUser = "user"
+	TargetBan = "ban"
+
+	AuditDeleteThread = "delete_thread"
+	AuditDeletePost = "delete_post"
+	AuditUnblockUser = "unblock_user"
+	AuditApproveThread = "approve_thread"
+	AuditApprovePost = "approve_post"
+	AuditRejectThread = "reject_thread"
+	AuditRejectPost = "reject_post"
+	AuditRestoreThread = "restore_thread"
+	AuditRestorePost = "restore_post"
+	AuditPurgeThread = "purge_thread"
+	AuditPurgePost = "purge_post"
+	AuditIssueBan = "issue_ban"
+	AuditLiftBan = "lift_ban"
+	AuditShadowBan = "shadow_ban"
+	AuditSetRole = "set_role"
+	AuditSetTrustLevel = "set_trust_level"
+)
+
+var (
+	AuditRetention = time.Hour * 24 * 365
+	errAppendOnly = goerrors.New("audit entries can't be changed")
+)
+
+// A record of a destructive or moderator action, with the target as it was before and after
+type AuditEntry struct {
+	BaseModel
+	Actor User `json:"actor"`
+	ActorID uint `json:"-"`
+	Action string `json:"action"`
+	TargetType string `json:"targetType"`
+	TargetID uint `json:"targetId"`
+	Before string `json:"before" gorm:"type:text"`
+	After string `json:"after" gorm:"type:text"`
+	IP string `json:"ip"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Filters for the audit log, zero values match everything
+type AuditQuery struct {
+	ActorID uint
+	Action string
+	TargetType string
+	TargetID uint
+	Timestamp int64
+	Limit int
+}
+
+// Keeps the log append only for everything going through gorm, UpdateColumn(s) included since they skip model hooks
+// Entries are only ever removed by PurgeAuditLog
+func init() {
+	gorm.DefaultCallback.Update().Before("gorm:update").Register("forum:audit_append_only", func(scope *gorm.Scope) {
+		if scope.TableName() == "audit_entries" {
+			scope.Err(errAppendOnly)
+		}
+	})
+	gorm.DefaultCallback.Delete().Before("gorm:delete").Register("forum:audit_append_only", func(scope *gorm.Scope) {
+		if _, purging := scope.Get(auditPurgeKey); scope.TableName() == "audit_entries" && !purging {
+			scope.Err(errAppendOnly)
+		}
+	})
+}
+
+func snapshot(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// Records an action in the audit log, db should be the transaction making the change so both happen or neither does
+// The IP address is read from the AuditIPKey setting on db when the request set one
+func recordAudit(db *gorm.DB, actor *User, action string, targetType string, targetID uint, before interface{}, after interface{}) error {
+	entry := AuditEntry{ActorID: actor.ID, Action: action, TargetType: targetType, TargetID: targetID,
+		Before: snapshot(before), After: snapshot(after), Timestamp: MakeTimestamp()}
+	if ip, exists := db.Get(AuditIPKey); exists {
+		entry.IP, _ = ip.(string)
+	}
+	return db.Create(&entry).Error
+}
+
+// Gets the audit entries older than query.Timestamp matching the filters, newest first
+func GetAuditLog(db *gorm.DB, query AuditQuery, entries *[]AuditEntry) {
+	scope := db.Preload("Actor").Order("timestamp desc, id desc").Limit(query.Limit).Where("timestamp < ?", query.Timestamp)
+	if query.ActorID > 0 {
+		scope = scope.Where("actor_id = ?", query.ActorID)
+	}
+	if query.Action != "" {
+		scope = scope.Where("action = ?", query.Action)
+	}
+	if query.TargetType != "" {
+		scope = scope.Where("target_type = ?", query.TargetType)
+	}
+	if query.TargetID > 0 {
+		scope = scope.Where("target_id = ?", query.TargetID)
+	}
+	scope.Find(entries)
+}
+
+// Removes the audit entries older than AuditRetention
+func PurgeAuditLog(db *gorm.DB) {
+	cutoff := MakeTimestamp() - int64(AuditRetention / time.Millisecond)
+	db.Set(auditPurgeKey, true).Where("timestamp < ?", cutoff).Delete(&AuditEntry{})
+}
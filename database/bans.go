@@ -0,0 +1,175 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/errors"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	BanUser = "user"
+	BanIP = "ip"
+	BanUsername = "username"
+)
+
+// A ban on a user, an IP address or range, or usernames matching a pattern
+// ExpiresAt is 0 for permanent bans, anything else is a suspension
+type Ban struct {
+	BaseModel
+	Kind string `json:"kind"`
+	UserID uint `json:"userId"`
+	Value string `json:"value"`
+	Reason string `json:"reason"`
+	Moderator User `json:"moderator"`
+	ModeratorID uint `json:"-"`
+	ExpiresAt int64 `json:"expiresAt"`
+	Lifted bool `json:"lifted"`
+	LiftedByID uint `json:"liftedById"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Turns the ban into the error shown to the banned user
+func (ban *Ban) UserError() *errors.UserError {
+	if ban.ExpiresAt > 0 {
+		return errors.ErrSuspended
+	}
+	return errors.ErrBanned
+}
+
+// Limits a ban query to bans that haven't been lifted or expired
+func activeBans(db *gorm.DB) *gorm.DB {
+	return db.Where("lifted = ? AND (expires_at = ? OR expires_at > ?)", false, 0, MakeTimestamp())
+}
+
+// Matches usernames against a pattern ban, ignoring case and requiring the whole username to match
+func compileUsernamePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)^(?:" + pattern + ")$")
+}
+
+// Bans a user, IP address (or CIDR range) or username pattern, for duration or permanently if it's 0
+func IssueBan(db *gorm.DB, moderator *User, kind string, userId uint, value string, reason string, duration time.Duration) (*Ban, *errors.UserError) {
+
+	if !moderator.IsModerator() {
+		return nil, errors.ErrForbidden
+	}
+
+	ban := Ban{Kind: kind, Reason: reason, ModeratorID: moderator.ID, Timestamp: MakeTimestamp()}
+	if duration > 0 {
+		ban.ExpiresAt = ban.Timestamp + int64(duration / time.Millisecond)
+	} else if duration < 0 {
+		return nil, errors.ErrBadRecord
+	}
+
+	switch kind {
+	case BanUser:
+		target, err := FindUser(db, userId)
+		if err != nil {
+			return nil, err
+		}
+		// Moderators can't ban each other, only admins can ban moderators
+		if target.ID == moderator.ID || (target.IsModerator() && !moderator.IsAdmin()) {
+			return nil, errors.ErrForbidden
+		}
+		ban.UserID = target.ID
+	case BanIP:
+		value = strings.TrimSpace(value)
+		if _, _, err := net.ParseCIDR(value); err != nil && net.ParseIP(value) == nil {
+			return nil, errors.ErrBadRecord
+		}
+		ban.Value = value
+	case BanUsername:
+		if _, err := compileUsernamePattern(value); err != nil || value == "" {
+			return nil, errors.ErrBadRecord
+		}
+		ban.Value = value
+	default:
+		return nil, errors.ErrBadRecord
+	}
+
+	tx := db.Begin()
+	if err := tx.Create(&ban).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.ErrSystem
+	}
+	if err := recordAudit(tx, moderator, AuditIssueBan, TargetBan, ban.ID, nil, ban); err != nil {
+		tx.Rollback()
+		return nil, errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, errors.ErrSystem
+	}
+	ban.Moderator = *moderator
+	return &ban, nil
+
+}
+
+// Finds the ban currently keeping the user out, permanent bans before suspensions, nil if there isn't one
+func FindActiveBan(db *gorm.DB, user *User) *Ban {
+	var ban Ban
+	activeBans(db).Where("kind = ? AND user_id = ?", BanUser, user.ID).Order("expires_at = 0 desc, expires_at desc").First(&ban)
+	if ban.ID > 0 {
+		return &ban
+	}
+	return nil
+}
+
+// Checks a new account's username and the IP address it's being registered from against the IP and pattern bans
+func CheckRegistrationBans(db *gorm.DB, username string, ip string) *errors.UserError {
+
+	var bans []Ban
+	activeBans(db).Where("kind IN (?)", []string{BanIP, BanUsername}).Find(&bans)
+
+	address := net.ParseIP(ip)
+	for _, ban := range bans {
+		switch ban.Kind {
+		case BanIP:
+			if _, network, err := net.ParseCIDR(ban.Value); err == nil {
+				if address != nil && network.Contains(address) {
+					return ban.UserError()
+				}
+			} else if banned := net.ParseIP(ban.Value); banned != nil && banned.Equal(address) {
+				return ban.UserError()
+			}
+		case BanUsername:
+			if pattern, err := compileUsernamePattern(ban.Value); err == nil && pattern.MatchString(username) {
+				return ban.UserError()
+			}
+		}
+	}
+	return nil
+
+}
+
+// Gets the bans issued before timestamp, newest first, only those still in force if active is true
+func GetBans(db *gorm.DB, timestamp int64, limit int, active bool, bans *[]Ban) {
+	query := db.Preload("Moderator").Order("timestamp desc").Limit(limit).Where("timestamp < ?", timestamp)
+	if active {
+		query = activeBans(query)
+	}
+	query.Find(bans)
+}
+
+// Lifts a ban before it expires
+func LiftBan(db *gorm.DB, moderator *User, id uint) *errors.UserError {
+	var ban Ban
+	activeBans(db).First(&ban, id)
+	if ban.ID < 1 {
+		return errors.ErrNotExist
+	}
+	tx := db.Begin()
+	if err := tx.Model(&ban).UpdateColumns(map[string]interface{}{"lifted": true, "lifted_by_id": moderator.ID}).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := recordAudit(tx, moderator, AuditLiftBan, TargetBan, ban.ID, map[string]bool{"lifted": false}, map[string]bool{"lifted": true}); err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/errors"
+	"ForumDatabase/helpers"
+	"time"
+)
+
+type Bookmark struct {
+	BaseModel
+	UserID uint `json:"-"`
+	TargetType string `json:"targetType"`
+	TargetID uint `json:"targetId"`
+	Note string `json:"note"`
+	Folder string `json:"folder"`
+	Timestamp int64 `json:"timestamp"`
+	Thread *Thread `json:"thread,omitempty" gorm:"-"`
+	Post *Post `json:"post,omitempty" gorm:"-"`
+	Deleted bool `json:"deleted" gorm:"-"`
+}
+
+type UserExport struct {
+	Username string `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+	Threads []Thread `json:"threads"`
+	Posts []Post `json:"posts"`
+	Bookmarks []Bookmark `json:"bookmarks"`
+}
+
+// Saves a thread or post for the user, with an optional private note and folder
+func AddBookmark(db *gorm.DB, user *User, targetType string, targetId uint, note string, folder string) (*Bookmark, *errors.UserError) {
+
+	if err := findTarget(db, user, targetType, targetId); err != nil {
+		return nil, err
+	}
+
+	var existing Bookmark
+	db.Where("user_id = ? AND target_type = ? AND target_id = ?", user.ID, targetType, targetId).First(&existing)
+	if existing.ID > 0 {
+		return nil, errors.ErrExists
+	}
+
+	bookmark := Bookmark{UserID: user.ID, TargetType: targetType, TargetID: targetId, Note: note, Folder: folder, Timestamp: MakeTimestamp()}
+	if err := db.Create(&bookmark).Error; err != nil {
+		return nil, errors.ErrExists
+	}
+	return &bookmark, nil
+
+}
+
+// Changes the note and folder of one of the user's bookmarks
+func UpdateBookmark(db *gorm.DB, user *User, id uint, note string, folder string) *errors.UserError {
+	var bookmark Bookmark
+	db.Where("user_id = ?", user.ID).First(&bookmark, id)
+	if bookmark.ID < 1 {
+		return errors.ErrNotExist
+	}
+	bookmark.Note = note
+	bookmark.Folder = folder
+	db.Save(&bookmark)
+	return nil
+}
+
+// Removes one of the user's bookmarks
+func DeleteBookmark(db *gorm.DB, user *User, id uint) *errors.UserError {
+	var bookmark Bookmark
+	db.Where("user_id = ?", user.ID).First(&bookmark, id)
+	if bookmark.ID > 0 {
+		db.Unscoped().Delete(&bookmark)
+		return nil
+	} else {
+		return errors.ErrNotExist
+	}
+}
+
+// Gets the user's bookmarks saved before timestamp, newest first, optionally only from one folder
+func GetBookmarks(db *gorm.DB, user *User, folder string, timestamp int64, limit int, bookmarks *[]Bookmark) {
+	query := db.Order("timestamp desc, id desc").Limit(limit).Where("user_id = ? AND timestamp < ?", user.ID, timestamp)
+	if folder != "" {
+		query = query.Where("folder = ?", folder)
+	}
+	query.Find(bookmarks)
+	loadBookmarkTargets(db, user, *bookmarks)
+}
+
+// Attaches the bookmarked content, leaving a tombstone for anything that's been deleted or the user can no longer see
+func loadBookmarkTargets(db *gorm.DB, user *User, bookmarks []Bookmark) {
+
+	var threadIds, postIds []uint
+	for _, bookmark := range bookmarks {
+		if bookmark.TargetType == TargetThread {
+			threadIds = append(threadIds, bookmark.TargetID)
+		} else {
+			postIds = append(postIds, bookmark.TargetID)
+		}
+	}
+
+	threads := make(map[uint]*Thread)
+	if len(threadIds) > 0 {
+		var found []Thread
+		visibleThreads(db, user).Preload("Authors").Where("threads.id IN (?) AND threads.deleted = ?", threadIds, false).Find(&found)
+		for i := range found {
+			threads[found[i].ID] = &found[i]
+		}
+	}
+
+	posts := make(map[uint]*Post)
+	if len(postIds) > 0 {
+		var found []Post
+		visiblePosts(db, user).Preload("Authors").Preload("Threads").Where("posts.id IN (?) AND posts.deleted = ?", postIds, false).Find(&found)
+
+		// A post is only as visible as the thread it's in, which are all checked together
+		var parentIds, visibleParentIds []uint
+		for _, post := range found {
+			if len(post.Threads) > 0 {
+				parentIds = append(parentIds, post.Threads[0].ID)
+			}
+		}
+		if len(parentIds) > 0 {
+			visibleThreads(db.Model(&Thread{}), user).Where("threads.id IN (?) AND threads.deleted = ?", parentIds, false).Pluck("threads.id", &visibleParentIds)
+		}
+		for i := range found {
+			if len(found[i].Threads) > 0 && helpers.UintInSlice(visibleParentIds, found[i].Threads[0].ID) {
+				posts[found[i].ID] = &found[i]
+			}
+		}
+	}
+
+	for i := range bookmarks {
+		if bookmarks[i].TargetType == TargetThread {
+			bookmarks[i].Thread = threads[bookmarks[i].TargetID]
+			bookmarks[i].Deleted = bookmarks[i].Thread == nil
+		} else {
+			bookmarks[i].Post = posts[bookmarks[i].TargetID]
+			bookmarks[i].Deleted = bookmarks[i].Post == nil
+		}
+	}
+
+}
+
+// Collects everything the user has written or saved
+func ExportUserData(db *gorm.DB, user *User) *UserExport {
+
+	export := UserExport{Username: user.Username, CreatedAt: user.CreatedAt}
+
+	db.Joins("INNER JOIN user_threads ON user_threads.thread_id = threads.id").Where("user_id = ?", user.ID).Order("timestamp").Find(&export.Threads)
+	db.Joins("INNER JOIN user_posts ON user_posts.post_id = posts.id").Where("user_id = ?", user.ID).Order("timestamp").Find(&export.Posts)
+	db.Where("user_id = ?", user.ID).Order("timestamp").Find(&export.Bookmarks)
+	loadBookmarkTargets(db, user, export.Bookmarks)
+
+	return &export
+
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/helpers"
+	"ForumDatabase/errors"
+	"ForumDatabase/markdown"
+	"fmt"
+)
+
+var (
+	MaxConversationParticipants = 20
+)
+
+type Conversation struct {
+	BaseModel
+	LastUpdate int64 `json:"lastUpdate"`
+	Participants []ConversationParticipant `json:"participants"`
+	UnreadCount int64 `json:"unreadCount" gorm:"-"`
+}
+
+type ConversationParticipant struct {
+	BaseModel
+	ConversationID uint `json:"-"`
+	User User `json:"user"`
+	UserID uint `json:"-"`
+	LastReadTimestamp int64 `json:"lastReadTimestamp"`
+	Left bool `json:"left"`
+}
+
+type Message struct {
+	BaseModel
+	ConversationID uint `json:"conversationId"`
+	Author User `json:"author"`
+	AuthorID uint `json:"-"`
+	Content string `json:"content" gorm:"type:text"`
+	ContentHTML string `json:"contentHtml" gorm:"-"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Renders the message content once it's loaded, messages can't be edited so there's only one revision
+func (message *Message) AfterFind() {
+	message.ContentHTML = markdown.Cached(fmt.Sprintf("message:%d", message.ID), 1, func() string {
+		return markdown.Render(message.Content, nil)
+	})
+}
+
+// Finds the user's participant record for a conversation they haven't left
+func findParticipant(db *gorm.DB, user *User, conversationId uint) (*ConversationParticipant, *errors.UserError) {
+	var participant ConversationParticipant
+	db.Where("conversation_id = ? AND user_id = ? AND `left` = ?", conversationId, user.ID, false).First(&participant)
+	if participant.ID > 0 {
+		return &participant, nil
+	} else {
+		return nil, errors.ErrNotExist
+	}
+}
+
+// Checks none of the users have blocked the sender
+func checkNotBlocked(db *gorm.DB, sender *User, userIds []uint) *errors.UserError {
+	var count int64
+	db.Model(&BlockRecord{}).Where("user_id IN (?) AND target_id = ?", userIds, sender.ID).Count(&count)
+	if count > 0 {
+		return errors.ErrBlocked
+	}
+	return nil
+}
+
+// Starts a conversation between the user and the participants with a first message
+func StartConversation(db *gorm.DB, user *User, participantIds []uint, content string) (*Conversation, *errors.UserError) {
+
+	if contentErr := helpers.ValidateContent(content); contentErr != nil {
+		return nil, contentErr
+	}
+
+	var others []uint
+	for _, id := range participantIds {
+		if id != user.ID && !helpers.UintInSlice(others, id) {
+			others = append(others, id)
+		}
+	}
+
+	if len(others) == 0 || len(others) + 1 > MaxConversationParticipants {
+		return nil, errors.ErrBadRecord
+	}
+
+	var count int64
+	db.Model(&User{}).Where("id IN (?)", others).Count(&count)
+	if count != int64(len(others)) {
+		return nil, errors.ErrNotExist
+	}
+
+	if blockErr := checkNotBlocked(db, user, others); blockErr != nil {
+		return nil, blockErr
+	}
+
+	timestamp := MakeTimestamp()
+	conversation := Conversation{LastUpdate: timestamp}
+	conversation.Participants = append(conversation.Participants, ConversationParticipant{UserID: user.ID, LastReadTimestamp: timestamp})
+	for _, id := range others {
+		conversation.Participants = append(conversation.Participants, ConversationParticipant{UserID: id})
+	}
+
+	tx := db.Begin()
+	if err := tx.Create(&conversation).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.ErrSystem
+	}
+	message := Message{ConversationID: conversation.ID, AuthorID: user.ID, Content: content, Timestamp: timestamp}
+	if err := tx.Create(&message).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, errors.ErrSystem
+	}
+
+	// Reloaded so the participants come back with their users, like they do when listing conversations
+	var created Conversation
+	if err := db.Preload("Participants").Preload("Participants.User").First(&created, conversation.ID).Error; err != nil {
+		return nil, errors.ErrSystem
+	}
+	return &created, nil
+
+}
+
+// Adds a message from the user to a conversation they're part of
+func SendMessage(db *gorm.DB, user *User, conversationId uint, content string) (*Message, *errors.UserError) {
+
+	if contentErr := helpers.ValidateContent(content); contentErr != nil {
+		return nil, contentErr
+	}
+
+	participant, err := findParticipant(db, user, conversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	var others []uint
+	db.Model(&ConversationParticipant{}).Where("conversation_id = ? AND user_id <> ? AND `left` = ?", conversationId, user.ID, false).Pluck("user_id", &others)
+	if len(others) == 0 {
+		return nil, errors.ErrNotExist
+	}
+
+	if blockErr := checkNotBlocked(db, user, others); blockErr != nil {
+		return nil, blockErr
+	}
+
+	timestamp := MakeTimestamp()
+	message := Message{ConversationID: conversationId, AuthorID: user.ID, Content: content, Timestamp: timestamp}
+
+	tx := db.Begin()
+	if createErr := tx.Create(&message).Error; createErr != nil {
+		tx.Rollback()
+		return nil, errors.ErrSystem
+	}
+	if updateErr := tx.Model(&Conversation{}).Where("id = ?", conversationId).Update("last_update", timestamp).Error; updateErr != nil {
+		tx.Rollback()
+		return nil, errors.ErrSystem
+	}
+	if updateErr := tx.Model(participant).Update("last_read_timestamp", timestamp).Error; updateErr != nil {
+		tx.Rollback()
+		return nil, errors.ErrSystem
+	}
+	if commitErr := tx.Commit().Error; commitErr != nil {
+		return nil, errors.ErrSystem
+	}
+
+	message.AfterFind()
+	return &message, nil
+
+}
+
+// Gets the conversations the user is part of, most recently active first
+func GetConversations(db *gorm.DB, user *User, timestamp int64, limit int, conversations *[]Conversation) {
+
+	db.Joins("INNER JOIN conversation_participants ON conversation_participants.conversation_id = conversations.id").
+			Preload("Participants").Preload("Participants.User").Order("last_update desc").Limit(limit).
+			Where("last_update < ? AND conversation_participants.user_id = ? AND conversation_participants.`left` = ?", timestamp, user.ID, false).
+			Find(conversations)
+
+	loadConversationUnreadCounts(db, user, *conversations)
+
+}
+
+// Fills in how many messages the user hasn't read in each conversation with one grouped query
+func loadConversationUnreadCounts(db *gorm.DB, user *User, conversations []Conversation) {
+
+	if len(conversations) == 0 {
+		return
+	}
+
+	var ids []uint
+	for _, conversation := range conversations {
+		ids = append(ids, conversation.ID)
+	}
+
+	unreadByConversation := make(map[uint]int64)
+	rows, err := db.Table("messages").Select("messages.conversation_id, COUNT(*)").
+			Joins("INNER JOIN conversation_participants ON conversation_participants.conversation_id = messages.conversation_id").
+			Where("messages.conversation_id IN (?) AND conversation_participants.user_id = ? AND messages.author_id <> ? AND messages.timestamp > conversation_participants.last_read_timestamp",
+				ids, user.ID, user.ID).
+			Group("messages.conversation_id").Rows()
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var conversationId uint
+			var count int64
+			rows.Scan(&conversationId, &count)
+			unreadByConversation[conversationId] = count
+		}
+	}
+
+	for i := range conversations {
+		conversations[i].UnreadCount = unreadByConversation[conversations[i].ID]
+	}
+
+}
+
+// Counts the unread messages across all the user's conversations
+func CountUnreadMessages(db *gorm.DB, user *User) int64 {
+	var count int64
+	db.Table("messages").Joins("INNER JOIN conversation_participants ON conversation_participants.conversation_id = messages.conversation_id").
+			Where("conversation_participants.user_id = ? AND conversation_participants.`left` = ? AND messages.author_id <> ? AND messages.timestamp > conversation_participants.last_read_timestamp",
+				user.ID, false, user.ID).Count(&count)
+	return count
+}
+
+// Gets messages older than timestamp from a conversation the user is part of, newest first
+func GetMessages(db *gorm.DB, user *User, conversationId uint, timestamp int64, limit int, messages *[]Message) *errors.UserError {
+	if _, err := findParticipant(db, user, conversationId); err != nil {
+		return err
+	}
+	db.Preload("Author").Order("timestamp desc").Limit(limit).Where("conversation_id = ? AND timestamp < ?", conversationId, timestamp).Find(messages)
+	return nil
+}
+
+// Marks every message in the conversation as read for the user
+func MarkConversationRead(db *gorm.DB, user *User, conversationId uint) *errors.UserError {
+	participant, err := findParticipant(db, user, conversationId)
+	if err != nil {
+		return err
+	}
+	db.Model(participant).Update("last_read_timestamp", MakeTimestamp())
+	return nil
+}
+
+// Removes the user from a conversation, they won't see it or receive new messages
+func LeaveConversation(db *gorm.DB, user *User, conversationId uint) *errors.UserError {
+	participant, err := findParticipant(db, user, conversationId)
+	if err != nil {
+		return err
+	}
+	db.Model(participant).Update("left", true)
+	return nil
+}
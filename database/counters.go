@@ -0,0 +1,138 @@
+package database
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+)
+
+// Only visible content counts: not deleted, held for moderation or shadowed
+const visiblePostCondition = "posts.deleted = false AND posts.held = false AND posts.shadowed = false"
+const visibleThreadCondition = "threads.deleted = false AND threads.held = false AND threads.shadowed = false"
+
+// A stored counter that didn't match its source tables when it was reconciled
+type CounterDrift struct {
+	Table string `json:"table"`
+	ID uint `json:"id"`
+	Column string `json:"column"`
+	Stored int64 `json:"stored"`
+	Actual int64 `json:"actual"`
+}
+
+func (post *Post) isVisible() bool {
+	return !post.Deleted && !post.Held && !post.Shadowed
+}
+
+func (thread *Thread) isVisible() bool {
+	return !thread.Deleted && !thread.Held && !thread.Shadowed
+}
+
+// Gets the id of the thread a post belongs to
+func findPostThreadId(db *gorm.DB, postId uint) uint {
+	var threadIds []uint
+	db.Table("thread_posts").Where("post_id = ?", postId).Pluck("thread_id", &threadIds)
+	if len(threadIds) == 0 {
+		return 0
+	}
+	return threadIds[0]
+}
+
+// Recounts the distinct users who wrote the thread or one of its visible posts
+func refreshParticipants(db *gorm.DB, threadId uint) error {
+	return db.Exec("UPDATE threads SET participants_count = (SELECT COUNT(*) FROM (" +
+		"SELECT user_id FROM user_threads WHERE thread_id = ? UNION " +
+		"SELECT user_posts.user_id FROM user_posts INNER JOIN thread_posts ON thread_posts.post_id = user_posts.post_id " +
+		"INNER JOIN posts ON posts.id = user_posts.post_id WHERE thread_posts.thread_id = ? AND " + visiblePostCondition +
+		") participants) WHERE id = ?", threadId, threadId, threadId).Error
+}
+
+// Moves the thread and author counters as a visible post appears (delta 1) or disappears (delta -1)
+func countPost(db *gorm.DB, threadId uint, authorId uint, delta int) error {
+	if err := db.Model(&Thread{}).Where("id = ?", threadId).UpdateColumn("posts_count", gorm.Expr("posts_count + ?", delta)).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&User{}).Where("id = ?", authorId).UpdateColumn("posts_count", gorm.Expr("posts_count + ?", delta)).Error; err != nil {
+		return err
+	}
+	if err := refreshParticipants(db, threadId); err != nil {
+		return err
+	}
+	refreshHotScore(db, threadId)
+	return nil
+}
+
+// Moves the author's thread counter as a visible thread appears (delta 1) or disappears (delta -1)
+func countThread(db *gorm.DB, authorId uint, delta int) error {
+	return db.Model(&User{}).Where("id = ?", authorId).UpdateColumn("threads_count", gorm.Expr("threads_count + ?", delta)).Error
+}
+
+// A stored counter and a subquery working out its true value for the row it's on
+type reconciledCounter struct {
+	table string
+	column string
+	actual string
+}
+
+var reconciledCounters = []reconciledCounter{
+	{"threads", "posts_count", "(SELECT COUNT(*) FROM thread_posts INNER JOIN posts ON posts.id = thread_posts.post_id " +
+		"WHERE thread_posts.thread_id = threads.id AND " + visiblePostCondition + ")"},
+	{"threads", "participants_count", "(SELECT COUNT(*) FROM users WHERE users.id IN (SELECT user_id FROM user_threads WHERE thread_id = threads.id) " +
+		"OR users.id IN (SELECT user_posts.user_id FROM user_posts INNER JOIN thread_posts ON thread_posts.post_id = user_posts.post_id " +
+		"INNER JOIN posts ON posts.id = user_posts.post_id WHERE thread_posts.thread_id = threads.id AND " + visiblePostCondition + "))"},
+	{"users", "threads_count", "(SELECT COUNT(*) FROM user_threads INNER JOIN threads ON threads.id = user_threads.thread_id " +
+		"WHERE user_threads.user_id = users.id AND " + visibleThreadCondition + ")"},
+	{"users", "posts_count", "(SELECT COUNT(*) FROM user_posts INNER JOIN posts ON posts.id = user_posts.post_id " +
+		"WHERE user_posts.user_id = users.id AND " + visiblePostCondition + ")"},
+}
+
+// Gets the rows whose counter doesn't match its source tables
+func findDrift(db *gorm.DB, counter reconciledCounter) ([]CounterDrift, error) {
+	var drifts []CounterDrift
+	rows, err := db.Raw(fmt.Sprintf("SELECT id, %s, %s FROM %s WHERE %s <> %s",
+		counter.column, counter.actual, counter.table, counter.column, counter.actual)).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		drift := CounterDrift{Table: counter.table, Column: counter.column}
+		if err := rows.Scan(&drift.ID, &drift.Stored, &drift.Actual); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, drift)
+	}
+	return drifts, rows.Err()
+}
+
+// Recomputes every counter from the source tables, fixing and returning the ones that had drifted
+// Each fix recounts as it writes, so content posted since the drift was found isn't lost
+func ReconcileCounters(db *gorm.DB) ([]CounterDrift, error) {
+
+	var drifts []CounterDrift
+	for _, counter := range reconciledCounters {
+		found, err := findDrift(db, counter)
+		if err != nil {
+			return drifts, err
+		}
+		if len(found) == 0 {
+			continue
+		}
+
+		var ids []uint
+		for _, drift := range found {
+			ids = append(ids, drift.ID)
+		}
+		err = db.Exec(fmt.Sprintf("UPDATE %s SET %s = %s WHERE id IN (?)", counter.table, counter.column, counter.actual), ids).Error
+		if err != nil {
+			return drifts, err
+		}
+		if counter.table == "threads" && counter.column == "posts_count" {
+			for _, id := range ids {
+				refreshHotScore(db, id)
+			}
+		}
+		drifts = append(drifts, found...)
+	}
+
+	return drifts, nil
+
+}
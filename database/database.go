@@ -3,12 +3,23 @@ package database
 import (
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	"github.com/go-sql-driver/mysql"
 	"time"
 	"golang.org/x/crypto/bcrypt"
 	"ForumDatabase/helpers"
 	"ForumDatabase/config"
 	"github.com/twinj/uuid"
 	"ForumDatabase/errors"
+	"ForumDatabase/markdown"
+	"fmt"
+	"log"
+	"reflect"
+)
+
+var (
+	// The first wait between connection attempts and the most it grows to
+	ConnectBackoff = time.Second
+	MaxConnectBackoff = time.Second * 30
 )
 
 var (
@@ -35,6 +46,13 @@ type User struct {
 	Posts        []Post `json:"-" gorm:"many2many:user_posts;"`
 	UniqueID     string `json:"-"`
 	BlockRecords []BlockRecord `json:"-"`
+	ReadWatermark int64 `json:"-"`
+	Role int `json:"role"`
+	PostsRead int64 `json:"-"`
+	TrustLevelOverride *int `json:"-"`
+	ShadowBanned bool `json:"-"`
+	ThreadsCount int64 `json:"threadsCount"`
+	PostsCount int64 `json:"postsCount"`
 }
 
 type BlockRecord struct {
@@ -46,79 +64,267 @@ type BlockRecord struct {
 
 type Thread struct {
 	BaseModel
-	Title string `json:"title" binding:"required"`
-	Content string `json:"content" binding:"required"`
+	Title string `json:"title"`
+	Content string `json:"content" gorm:"type:text"`
+	ContentHTML string `json:"contentHtml" gorm:"-"`
+	Revision int `json:"revision"`
 	Timestamp int64 `json:"timestamp"`
 	LastUpdate int64 `json:"lastUpdate"`
 	Deleted bool `json:"-"`
+	DeletedTimestamp int64 `json:"deletedTimestamp,omitempty"`
+	Held bool `json:"held"`
+	Shadowed bool `json:"-"`
 	Authors []User `json:"authors" gorm:"many2many:user_threads;"`
 	Posts []Post `json:"posts" gorm:"many2many:thread_posts"`
 	PostsCount int64 `json:"postsCount"`
+	ParticipantsCount int64 `json:"participantsCount"`
+	ReactionsCount int64 `json:"reactionsCount"`
+	HotScore float64 `json:"-"`
+	Reactions []ReactionCount `json:"reactions" gorm:"-"`
+	MyReactions []string `json:"myReactions" gorm:"-"`
+	Poll *Poll `json:"poll,omitempty" gorm:"-"`
+	Attachments []Attachment `json:"attachments,omitempty" gorm:"-"`
+	UnreadCount *int64 `json:"unreadCount,omitempty" gorm:"-"`
+	FirstUnreadPostID *uint `json:"firstUnreadPostId,omitempty" gorm:"-"`
+	WindowReactionsCount *int64 `json:"windowReactionsCount,omitempty" gorm:"-"`
 }
 
 type Post struct {
 	BaseModel
 	Threads []Thread `json:"threads" gorm:"many2many:thread_posts"`
 	Authors []User `json:"authors" gorm:"many2many:user_posts;"`
-	Content string `json:"content" binding:"required"`
+	Content string `json:"content" gorm:"type:text"`
+	ContentHTML string `json:"contentHtml" gorm:"-"`
+	Revision int `json:"revision"`
 	Deleted bool `json:"-"`
+	DeletedTimestamp int64 `json:"deletedTimestamp,omitempty"`
+	Held bool `json:"held"`
+	Shadowed bool `json:"-"`
 	Timestamp int64 `json:"timestamp"`
+	ParentPostID uint `json:"parentPostId"`
+	Replies []Post `json:"replies,omitempty" gorm:"-"`
+	HasMoreReplies bool `json:"hasMoreReplies,omitempty" gorm:"-"`
+	Reactions []ReactionCount `json:"reactions" gorm:"-"`
+	MyReactions []string `json:"myReactions" gorm:"-"`
+	Attachments []Attachment `json:"attachments,omitempty" gorm:"-"`
+}
+
+func init() {
+	gorm.DefaultCallback.Query().After("gorm:after_query").Register("forum:render_content", renderQueried)
+}
+
+// Renders the content of every thread and post a query loaded, cached by revision. The mentions behind any cache
+// misses are loaded together, so a page of results costs one extra query rather than one per row
+func renderQueried(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+
+	var threads []*Thread
+	var posts []*Post
+	collect := func(value reflect.Value) {
+		if value.Kind() != reflect.Ptr {
+			value = value.Addr()
+		}
+		switch loaded := value.Interface().(type) {
+		case *Thread:
+			threads = append(threads, loaded)
+		case *Post:
+			posts = append(posts, loaded)
+		}
+	}
+
+	if results := scope.IndirectValue(); results.Kind() == reflect.Slice {
+		for i := 0; i < results.Len(); i++ {
+			collect(results.Index(i))
+		}
+	} else if results.CanAddr() {
+		collect(results)
+	}
+
+	if len(threads) > 0 {
+		renderThreads(scope.NewDB(), threads)
+	}
+	if len(posts) > 0 {
+		renderPosts(scope.NewDB(), posts)
+	}
 }
 
-// Gets a connection to the database
-func MakeConnection(test bool) *gorm.DB {
+func renderThreads(db *gorm.DB, threads []*Thread) {
+	var missing []uint
+	for _, thread := range threads {
+		if _, cached := markdown.Lookup(fmt.Sprintf("thread:%d", thread.ID), thread.Revision); !cached {
+			missing = append(missing, thread.ID)
+		}
+	}
+	mentions := loadMentionedUsernames(db, TargetThread, missing)
+	for _, thread := range threads {
+		thread.ContentHTML = markdown.Cached(fmt.Sprintf("thread:%d", thread.ID), thread.Revision, func() string {
+			return markdown.Render(thread.Content, mentions[thread.ID])
+		})
+	}
+}
 
-	connectionString, stringErr := config.GetConnectionString(test)
-	if stringErr != nil {
-		panic(stringErr)
+func renderPosts(db *gorm.DB, posts []*Post) {
+	var missing []uint
+	for _, post := range posts {
+		if _, cached := markdown.Lookup(fmt.Sprintf("post:%d", post.ID), post.Revision); !cached {
+			missing = append(missing, post.ID)
+		}
+	}
+	mentions := loadMentionedUsernames(db, TargetPost, missing)
+	for _, post := range posts {
+		post.ContentHTML = markdown.Cached(fmt.Sprintf("post:%d", post.ID), post.Revision, func() string {
+			return markdown.Render(post.Content, mentions[post.ID])
+		})
+	}
+}
+
+func (thread *Thread) renderContent(db *gorm.DB) {
+	renderThreads(db, []*Thread{thread})
+}
+
+func (post *Post) renderContent(db *gorm.DB) {
+	renderPosts(db, []*Post{post})
+}
+
+// Gets a connection to the database, retrying with backoff while it can't be reached
+func MakeConnection(test bool) (*gorm.DB, error) {
+	configData, err := config.LoadConfigWithViper()
+	if err != nil {
+		return nil, err
 	}
+	return Connect(configData, test)
+}
 
-	db, err := gorm.Open("mysql", connectionString)
+// Connects to the primary with the given config
+func Connect(configData *config.ConfigData, test bool) (*gorm.DB, error) {
+	connectionString, err := configData.ConnectionString(test)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return open(configData, connectionString)
+}
 
-	// TODO: Need to check if these settings are appropriate
-	db.DB().SetConnMaxLifetime(time.Hour * 10)
-	db.DB().SetMaxIdleConns(0)
-	db.DB().SetMaxOpenConns(20)
+// Connects to the read replica, nil when there isn't one configured so reads stay on the primary
+func ConnectReplica(configData *config.ConfigData, test bool) (*gorm.DB, error) {
+	connectionString, err := configData.ReplicaConnectionString(test)
+	if err != nil || connectionString == "" {
+		return nil, err
+	}
+	return open(configData, connectionString)
+}
+
+// Opens a connection, trying db_connect_attempts times and doubling the wait after each failure
+// so the forum can start alongside a database that's still coming up
+func open(configData *config.ConfigData, connectionString string) (*gorm.DB, error) {
+
+	var err error
+	attempts := configData.DBConnectAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := ConnectBackoff
+
+	var db *gorm.DB
+	for attempt := 1; ; attempt++ {
+		// gorm pings the database before handing the connection back, so this fails if it's unreachable
+		db, err = gorm.Open("mysql", connectionString)
+		if err == nil {
+			break
+		}
+		if attempt == attempts {
+			return nil, fmt.Errorf("can't connect to the database after %d attempts: %v", attempts, err)
+		}
+		log.Printf("Database connection attempt %d of %d failed, retrying in %s: %v", attempt, attempts, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > MaxConnectBackoff {
+			backoff = MaxConnectBackoff
+		}
+	}
+
+	db.DB().SetConnMaxLifetime(configData.DBConnMaxLifetime)
+	db.DB().SetMaxIdleConns(configData.DBMaxIdleConns)
+	db.DB().SetMaxOpenConns(configData.DBMaxOpenConns)
+
+	return db, nil
 
-	return db
 }
 
 // Does the auto-migrations, sets up the unique constraint indexes
-func Setup(db *gorm.DB) {
-	db.AutoMigrate(&User{}, &Thread{}, &Post{}, &BlockRecord{})
+func Setup(db *gorm.DB) error {
+	if err := db.AutoMigrate(models...).Error; err != nil {
+		return err
+	}
+	// Content columns were created as varchar(255) before they were typed, widen them to fit MaxLengthContent
+	db.Model(&Thread{}).ModifyColumn("content", "text")
+	db.Model(&Post{}).ModifyColumn("content", "text")
+	db.Model(&Message{}).ModifyColumn("content", "text")
 	db.Model(&BlockRecord{}).AddUniqueIndex("BlockRecordIndex", "target_id", "user_id")
+	db.Model(&Mention{}).AddUniqueIndex("MentionIndex", "user_id", "thread_id", "post_id")
+	db.Model(&Notification{}).AddIndex("NotificationUserIndex", "user_id", "timestamp")
+	db.Model(&Post{}).AddIndex("PostParentIndex", "parent_post_id")
+	db.Model(&Quote{}).AddUniqueIndex("QuoteIndex", "post_id", "source_post_id")
+	db.Model(&Reaction{}).AddUniqueIndex("ReactionIndex", "user_id", "target_type", "target_id", "emoji")
+	db.Model(&Reaction{}).AddIndex("ReactionTargetIndex", "target_type", "target_id")
+	db.Model(&Reaction{}).AddIndex("ReactionWindowIndex", "target_type", "target_id", "timestamp")
+	db.Model(&Poll{}).AddUniqueIndex("PollThreadIndex", "thread_id")
+	db.Model(&PollVote{}).AddUniqueIndex("PollVoteIndex", "poll_id", "option_id", "user_id")
+	db.Model(&Attachment{}).AddIndex("AttachmentPostIndex", "post_id")
+	db.Model(&Attachment{}).AddIndex("AttachmentThreadIndex", "thread_id")
+	db.Model(&Attachment{}).AddIndex("AttachmentUserIndex", "user_id")
+	db.Model(&ConversationParticipant{}).AddUniqueIndex("ConversationParticipantIndex", "conversation_id", "user_id")
+	db.Model(&Message{}).AddIndex("MessageConversationIndex", "conversation_id", "timestamp")
+	db.Model(&Draft{}).AddUniqueIndex("DraftContextIndex", "user_id", "thread_id")
+	db.Model(&Draft{}).AddIndex("DraftExpiryIndex", "expires_at")
+	db.Model(&ReadPosition{}).AddUniqueIndex("ReadPositionIndex", "user_id", "thread_id")
+	db.Model(&Bookmark{}).AddUniqueIndex("BookmarkIndex", "user_id", "target_type", "target_id")
+	db.Model(&Bookmark{}).AddIndex("BookmarkTimestampIndex", "user_id", "timestamp")
+	db.Model(&Flag{}).AddUniqueIndex("FlagIndex", "user_id", "target_type", "target_id")
+	db.Model(&Flag{}).AddIndex("FlagAuthorIndex", "author_id")
+	db.Model(&Ban{}).AddIndex("BanUserIndex", "kind", "user_id")
+	db.Model(&AuditEntry{}).AddIndex("AuditTimestampIndex", "timestamp")
+	db.Model(&AuditEntry{}).AddIndex("AuditActorIndex", "actor_id", "timestamp")
+	db.Model(&AuditEntry{}).AddIndex("AuditTargetIndex", "target_type", "target_id")
+	db.Model(&Thread{}).AddIndex("ThreadDeletedIndex", "deleted", "deleted_timestamp")
+	db.Model(&Post{}).AddIndex("PostDeletedIndex", "deleted", "deleted_timestamp")
+	db.Model(&Thread{}).AddIndex("ThreadTimestampIndex", "timestamp")
+	db.Model(&Thread{}).AddIndex("ThreadLastUpdateIndex", "last_update")
+	db.Model(&Thread{}).AddIndex("ThreadPostsCountIndex", "posts_count")
+	db.Model(&Thread{}).AddIndex("ThreadReactionsCountIndex", "reactions_count")
+	db.Model(&Thread{}).AddIndex("ThreadHotScoreIndex", "hot_score")
+	db.Model(&Thread{}).AddIndex("ThreadHeldIndex", "held")
+	db.Model(&Post{}).AddIndex("PostHeldIndex", "held")
+	db.Model(&User{}).AddUniqueIndex("UserUsernameIndex", "username")
+	db.Model(&User{}).AddUniqueIndex("UserUniqueIDIndex", "unique_id")
 	db.Table("thread_posts").AddUniqueIndex("ThreadPostsIndex", "thread_id", "post_id")
 	db.Table("user_threads").AddUniqueIndex("UserThreadsIndex", "user_id", "thread_id")
 	db.Table("user_posts").AddUniqueIndex("UserPostsIndex", "user_id", "post_id")
+	return nil
 }
 
 // Creates a new user from the username and password(which gets encrypted)
 func CreateUser(db *gorm.DB, username string, password string) *errors.UserError {
 
+	validator := new (helpers.Validator)
+	validator.Merge(helpers.ValidateUsername(username))
+	validator.Merge(helpers.ValidatePassword(password, username))
+	if validationError := validator.Error(); validationError != nil {
+		return validationError
+	}
+
 	unique := uuid.NewV4().String()
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil { return errors.ErrSystem }
 
-	var existingUser User
-	db.First(&existingUser, "username = ? OR unique_id = ?", username, unique)
-
-	if existingUser.ID > 0 {
-		return errors.ErrExists
-	}
-
-	if usernameError := helpers.ValidateUsername(username); usernameError != nil {
-		return usernameError
-	}
-
-	if passwordError := helpers.ValidatePassword(password); passwordError != nil {
-		return passwordError
-	}
-
+	// The unique indexes settle concurrent registrations for the same username
 	newUser := User{Username: username, Password: string(hash), UniqueID: unique}
-	db.Save(&newUser)
+	if err := db.Create(&newUser).Error; err != nil {
+		if isDuplicateKey(err) {
+			return errors.ErrExists
+		}
+		return errors.ErrSystem
+	}
 	return nil
 
 }
@@ -145,12 +351,23 @@ func FindUserByUnique(db *gorm.DB, unique string) (*User, *errors.UserError) {
 	}
 }
 
+// Finds a user by username
+func FindUserByUsername(db *gorm.DB, username string) (*User, *errors.UserError) {
+	var user User
+	db.Where("username = ?", username).First(&user)
+	if user.ID > 0 {
+		return &user, nil
+	} else {
+		return nil, errors.ErrNotExist
+	}
+}
+
 // Finds a user with the given credentials, returns error if user can't be found/credentials are incorrect
 func FindUserByCredentials(db *gorm.DB, username string, password string) (*User, *errors.UserError) {
 	var user User
 	db.Where("username = ?", username).First(&user)
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, errors.ErrNotExist
+		return nil, errors.ErrBadCredentials
 	}
 	return &user, nil
 }
@@ -162,10 +379,11 @@ func CountTotalThreads(db *gorm.DB) int64 {
 	return count
 }
 
-// Count number of posts for a thread using its id
+// Count number of visible posts for a thread using its id
 func CountPostsForThread(db *gorm.DB, id uint) int64 {
 	var count int64
-	db.Table("thread_posts").Where("thread_id = ?", id).Count(&count)
+	db.Table("thread_posts").Joins("INNER JOIN posts ON posts.id = thread_posts.post_id").
+			Where("thread_posts.thread_id = ? AND " + visiblePostCondition, id).Count(&count)
 	return count
 }
 
@@ -180,6 +398,17 @@ func FindThread(db *gorm.DB, id uint) (*Thread, *errors.UserError) {
 	}
 }
 
+// Finds a post by id if it hasn't been deleted
+func FindPost(db *gorm.DB, id uint) (*Post, *errors.UserError) {
+	var post Post
+	db.Where("deleted = ?", false).First(&post, id)
+	if post.ID > 0 {
+		return &post, nil
+	} else {
+		return nil, errors.ErrNotExist
+	}
+}
+
 // Returns a thread by id but only if the user is the author of it
 func FindUserThread(db *gorm.DB, user *User, id uint) (*Thread, *errors.UserError) {
 	var thread Thread
@@ -204,18 +433,71 @@ func FindUserPost(db *gorm.DB, user *User, id uint) (*Post, *errors.UserError) {
 
 // Creates a thread for the specified user
 func CreateThread(db *gorm.DB, user *User, title string, content string) (*Thread, *errors.UserError) {
+	return CreateThreadWithPoll(db, user, title, content, nil)
+}
+
+// Creates a thread for the specified user, with a poll attached if poll isn't nil
+func CreateThreadWithPoll(db *gorm.DB, user *User, title string, content string, poll *NewPoll) (*Thread, *errors.UserError) {
+
+	validator := new (helpers.Validator)
+	validator.Merge(helpers.ValidateTitle(title))
+	validator.Merge(helpers.ValidateContent(content))
+	if poll != nil {
+		validator.Merge(ValidatePoll(poll))
+	}
+	if validationError := validator.Error(); validationError != nil {
+		return nil, validationError
+	}
 
-	if titleError := helpers.ValidateTitle(title); titleError != nil {
-		return nil, titleError
+	if trustErr := checkTrust(db, user, title + "\n" + content, true); trustErr != nil {
+		return nil, trustErr
 	}
 
-	if contentError := helpers.ValidateContent(content); contentError != nil {
-		return nil, contentError
+	held, spamErr := assessSpam(db, user, content)
+	if spamErr != nil {
+		return nil, spamErr
 	}
 
 	timestamp := MakeTimestamp()
-	thread := Thread{Title: title, Content: content, Timestamp: timestamp, LastUpdate: timestamp}
-	db.Model(&user).Association("Threads").Append(&thread)
+	thread := Thread{Title: title, Content: content, Revision: 1, Timestamp: timestamp, LastUpdate: timestamp, Held: held, Shadowed: user.ShadowBanned,
+		ParticipantsCount: 1}
+	thread.HotScore = HotScore(&thread)
+
+	// The new thread draft is removed in the same transaction so it's never lost or left behind
+	tx := db.Begin()
+	if err := tx.Model(&user).Association("Threads").Append(&thread).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.ErrSystem
+	}
+	if poll != nil {
+		created, err := createPoll(tx, thread.ID, poll)
+		if err != nil {
+			tx.Rollback()
+			return nil, errors.ErrSystem
+		}
+		thread.Poll = created
+	}
+	// Held threads only notify the mentioned users or count towards the author's threads once they're approved,
+	// shadowed threads never do
+	if thread.isVisible() {
+		if err := RecordMentions(tx, user, thread.ID, 0, content); err != nil {
+			tx.Rollback()
+			return nil, errors.ErrSystem
+		}
+		if err := countThread(tx, user.ID, 1); err != nil {
+			tx.Rollback()
+			return nil, errors.ErrSystem
+		}
+	}
+	if err := deleteDraft(tx, user, 0); err != nil {
+		tx.Rollback()
+		return nil, errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, errors.ErrSystem
+	}
+
+	thread.renderContent(db)
 	return &thread, nil
 
 }
@@ -257,7 +539,18 @@ func UnblockUser(db *gorm.DB, user *User, targetID uint) *errors.UserError {
 	var record BlockRecord
 	db.Where("target_id = ? AND user_id = ?", targetID, user.ID).First(&record)
 	if record.ID > 0 {
-		db.Unscoped().Delete(&record)
+		tx := db.Begin()
+		if err := tx.Unscoped().Delete(&record).Error; err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+		if err := recordAudit(tx, user, AuditUnblockUser, TargetUser, targetID, record, nil); err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+		if err := tx.Commit().Error; err != nil {
+			return errors.ErrSystem
+		}
 		return nil
 	} else {
 		return errors.ErrNotExist
@@ -266,33 +559,111 @@ func UnblockUser(db *gorm.DB, user *User, targetID uint) *errors.UserError {
 
 // Replies with content using the threadId
 func ReplyToThread(db *gorm.DB, user *User, threadId uint, content string) (*Post, *errors.UserError) {
+	return ReplyToPost(db, user, threadId, 0, content)
+}
+
+// Replies with content using the threadId, as a reply to parentPostId if it isn't 0
+func ReplyToPost(db *gorm.DB, user *User, threadId uint, parentPostId uint, content string) (*Post, *errors.UserError) {
 
 	if contentErr := helpers.ValidateContent(content); contentErr != nil {
 		return nil, contentErr
 	}
 
-	if thread, err := FindThread(db, threadId); err != nil {
+	if thread, err := FindVisibleThread(db, user, threadId); err != nil {
 		return nil, err
 	} else {
+		if parentPostId > 0 {
+			if _, parentErr := FindThreadPost(db, threadId, parentPostId); parentErr != nil {
+				return nil, errors.ErrBadRecord
+			}
+		}
+
+		if trustErr := checkTrust(db, user, content, false); trustErr != nil {
+			return nil, trustErr
+		}
+
+		held, spamErr := assessSpam(db, user, content)
+		if spamErr != nil {
+			return nil, spamErr
+		}
+
 		timestamp := MakeTimestamp()
-		post := Post{Content: content, Timestamp: timestamp}
-		db.Model(&thread).Association("Posts").Append(&post)
-		db.Model(&user).Association("Posts").Append(&post)
-		thread.LastUpdate = timestamp
-		thread.PostsCount = thread.PostsCount + 1
-		db.Save(&thread)
+		post := Post{Content: content, ParentPostID: parentPostId, Revision: 1, Timestamp: timestamp, Held: held, Shadowed: user.ShadowBanned}
+
+		// The reply draft for this thread is removed in the same transaction so it's never lost or left behind
+		tx := db.Begin()
+		if err := tx.Model(&thread).Association("Posts").Append(&post).Error; err != nil {
+			tx.Rollback()
+			return nil, errors.ErrSystem
+		}
+		if err := tx.Model(&user).Association("Posts").Append(&post).Error; err != nil {
+			tx.Rollback()
+			return nil, errors.ErrSystem
+		}
+		if err := RecordQuotes(tx, thread.ID, post.ID, content); err != nil {
+			tx.Rollback()
+			return nil, errors.ErrSystem
+		}
+
+		// Held posts don't count towards the thread's activity or notify anyone until they're approved,
+		// and shadowed posts never do so the thread doesn't give them away
+		if !held && !post.Shadowed {
+			if err := RecordMentions(tx, user, thread.ID, post.ID, content); err != nil {
+				tx.Rollback()
+				return nil, errors.ErrSystem
+			}
+			if err := bumpThread(tx, thread.ID, user.ID, timestamp); err != nil {
+				tx.Rollback()
+				return nil, errors.ErrSystem
+			}
+		}
+		if err := deleteDraft(tx, user, thread.ID); err != nil {
+			tx.Rollback()
+			return nil, errors.ErrSystem
+		}
+		if err := tx.Commit().Error; err != nil {
+			return nil, errors.ErrSystem
+		}
+
+		post.renderContent(db)
 		return &post, nil
 	}
 
 }
 
+// Counts a new post towards the thread's activity, incrementing in place so concurrent replies aren't lost
+func bumpThread(db *gorm.DB, threadId uint, authorId uint, timestamp int64) error {
+	err := db.Model(&Thread{}).Where("id = ?", threadId).UpdateColumn("last_update", gorm.Expr("GREATEST(last_update, ?)", timestamp)).Error
+	if err != nil {
+		return err
+	}
+	return countPost(db, threadId, authorId, 1)
+}
+
 // Marks the thread with supplied id as deleted if it can be found/the user has permission
 func DeleteThread(db *gorm.DB, user *User, threadId uint) *errors.UserError {
 	if thread, err := FindUserThread(db, user, threadId); err != nil {
 		return err
 	} else {
-		thread.Deleted = true
-		db.Save(&thread)
+		visible := thread.isVisible()
+		tx := db.Begin()
+		if err := tx.Model(thread).UpdateColumns(map[string]interface{}{"deleted": true, "deleted_timestamp": MakeTimestamp()}).Error; err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+		if visible {
+			if err := countThread(tx, user.ID, -1); err != nil {
+				tx.Rollback()
+				return errors.ErrSystem
+			}
+		}
+		if err := recordAudit(tx, user, AuditDeleteThread, TargetThread, thread.ID, thread, map[string]bool{"deleted": true}); err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+		if err := tx.Commit().Error; err != nil {
+			return errors.ErrSystem
+		}
 		return nil
 	}
 }
@@ -302,35 +673,59 @@ func DeletePost(db *gorm.DB, user *User, postId uint) *errors.UserError {
 	if post, err := FindUserPost(db, user, postId); err != nil {
 		return err
 	} else {
-		post.Deleted = true
-		db.Save(&post)
+		visible := post.isVisible()
+		tx := db.Begin()
+		if err := tx.Model(post).UpdateColumns(map[string]interface{}{"deleted": true, "deleted_timestamp": MakeTimestamp()}).Error; err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+		if visible {
+			if err := countPost(tx, findPostThreadId(db, post.ID), user.ID, -1); err != nil {
+				tx.Rollback()
+				return errors.ErrSystem
+			}
+		}
+		if err := recordAudit(tx, user, AuditDeletePost, TargetPost, post.ID, post, map[string]bool{"deleted": true}); err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+		if err := tx.Commit().Error; err != nil {
+			return errors.ErrSystem
+		}
 		return nil
 	}
 }
 
 // Gets latest threads if the user isn't authenticated/user has no block records
 func GetLatestThreads(db *gorm.DB, timestamp int64, limit int, threads *[]Thread) {
-	db.Preload("Authors").Preload("Posts").Preload("Posts.Authors").Order("last_update desc").Limit(limit).Where("timestamp < ? AND deleted = ?", timestamp, false).Find(&threads)
+	GetThreads(db, nil, ThreadQuery{Sort: SortActive, Timestamp: timestamp, Limit: limit}, threads)
 }
 
 // Gets latest threads
 func GetLatestThreadsForUser(db *gorm.DB, user *User, timestamp int64, limit int, threads *[]Thread) {
-	var blockedIDs []int
-	GetBlockedIds(db, user, &blockedIDs)
-	if len(blockedIDs) > 0 {
-		db.Joins("INNER JOIN user_threads ON user_threads.thread_id = threads.id").Order("last_update desc").Preload("Authors").Preload("Posts").Preload("Posts.Authors").
-				Limit(limit).Where("timestamp < ? AND user_id NOT IN (?) AND deleted = ?", timestamp, blockedIDs, false).Find(&threads)
-	} else {
-		GetLatestThreads(db, timestamp, limit, threads)
-	}
+	GetThreads(db, user, ThreadQuery{Sort: SortActive, Timestamp: timestamp, Limit: limit}, threads)
 }
 
 // Gets posts for the thread with the supplied id
 func GetPostsForThread(db *gorm.DB, timestamp int64, limit int, threadId uint, posts *[]Post) {
-	db.Joins("INNER JOIN thread_posts ON thread_posts.post_id = posts.id").Order("timestamp").Preload("Authors").
+	GetPostsForThreadForUser(db, nil, timestamp, limit, threadId, posts)
+}
+
+// Gets posts for the thread with the supplied id that the viewer can see, nothing if they can't see the thread
+func GetPostsForThreadForUser(db *gorm.DB, viewer *User, timestamp int64, limit int, threadId uint, posts *[]Post) {
+	if _, err := FindVisibleThread(db, viewer, threadId); err != nil {
+		return
+	}
+	visiblePosts(db, viewer).Joins("INNER JOIN thread_posts ON thread_posts.post_id = posts.id").Order("timestamp").Preload("Authors").
 			Limit(limit).Where("timestamp < ? AND thread_id = ? AND deleted = ?", timestamp, threadId, false).Find(&posts)
 }
 
+// Checks whether a write failed because it broke a unique index
+func isDuplicateKey(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
+
 // Creates a epoch millisecond timestamp
 func MakeTimestamp() int64 {
 	return time.Now().UnixNano() / int64(time.Millisecond)
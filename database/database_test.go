@@ -5,16 +5,53 @@ import (
 	"github.com/jinzhu/gorm"
 	"fmt"
 	"ForumDatabase/helpers"
+	"strings"
+	"ForumDatabase/blobstore"
+	"io/ioutil"
+	"os"
+	"bytes"
+	"image"
+	"image/png"
+	"time"
+	"ForumDatabase/spam"
+	"ForumDatabase/errors"
+	"sync"
 )
 
-var db *gorm.DB = MakeConnection(true)
+var (
+	db *gorm.DB = mustConnect()
+)
+
+func mustConnect() *gorm.DB {
+	db, err := MakeConnection(true)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// The tests write far faster than any real user, so spam scoring, trust levels and the rate limit are only on where they're tested
+func disableAbuseChecks() {
+	SpamPipeline = nil
+	TrustLevels = nil
+	PostRateLimit = 0
+}
 
 func TestClear(t *testing.T) {
-	db.Exec("DROP TABLE block_records, posts, thread_posts, threads, user_posts, user_threads, users")
+	disableAbuseChecks()
+	db.Exec("DROP TABLE block_records, posts, thread_posts, threads, user_posts, user_threads, users, mentions, notifications, quotes, reactions, polls, poll_options, poll_votes, attachments, conversations, conversation_participants, messages, drafts, read_positions, bookmarks, flags, bans, audit_entries")
 }
 
 func TestSetup(t *testing.T) {
-	Setup(db)
+	if err := CheckSchema(db); err == nil {
+		t.Error("Expected the dropped tables to be reported missing")
+	}
+	if err := Setup(db); err != nil {
+		t.Error("Unexpected error migrating: ", err)
+	}
+	if err := CheckSchema(db); err != nil {
+		t.Error("Expected every table to exist after migrating, got ", err)
+	}
 }
 
 func TestCreateUser(t *testing.T) {
@@ -61,6 +98,20 @@ func TestCreateThread(t *testing.T) {
 
 }
 
+func TestThreadContentHTML(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	thread, err := CreateThread(db, user, "Markdown thread title", "Some **bold** text <script>alert(1)</script>")
+	if err != nil {
+		t.Error("Error creating thread", err)
+		return
+	}
+
+	found, _ := FindThread(db, thread.ID)
+	if found == nil || !strings.Contains(found.ContentHTML, "<strong>bold</strong>") || strings.Contains(found.ContentHTML, "<script") {
+		t.Error("Expected rendered and sanitized content")
+	}
+}
+
 func TestFindUser2(t *testing.T) {
 	_, err := FindUser(db, 200000)
 	if err == nil {
@@ -220,10 +271,983 @@ func TestGetLatestThreadsForUser(t *testing.T) {
 	}
 }
 
+func TestRecordMentions(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	thread, err := CreateThread(db, user, "Mentioning another user", "@goldtime34 see above, @nobodyhere too")
+	if err != nil {
+		t.Error("Unexpected error creating thread", err)
+		return
+	}
+
+	mentions := GetMentionedUsernames(db, thread.ID, 0)
+	if len(mentions) != 1 || mentions[0] != TEST_USER2.Username {
+		t.Error("Expected only the existing user to be mentioned: ", mentions)
+	}
+
+	if !strings.Contains(thread.ContentHTML, `class="mention"`) {
+		t.Error("Expected mention to be linked: ", thread.ContentHTML)
+	}
+
+	mentioned, _ := FindUser(db, 2)
+	if CountUnreadNotifications(db, mentioned) < 1 {
+		t.Error("Expected mentioned user to be notified")
+	}
+}
+
+func TestRecordMentionsBlocked(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	mentioned, _ := FindUser(db, 2)
+	BlockUser(db, mentioned, user.ID)
+	defer UnblockUser(db, mentioned, user.ID)
+
+	before := CountUnreadNotifications(db, mentioned)
+	if _, err := CreateThread(db, user, "Mentioning a blocker", "@goldtime34 you won't hear about this"); err != nil {
+		t.Error("Unexpected error creating thread", err)
+	}
+
+	if CountUnreadNotifications(db, mentioned) != before {
+		t.Error("Expected no notification when the author is blocked")
+	}
+}
+
+func TestReplyToPost(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	thread, _ := CreateThread(db, user, "Thread with nested replies", "Replies to this thread are going to be nested")
+	other, _ := CreateThread(db, user, "Some unrelated other thread", "Posts in here can't be the parent of the first thread's posts")
+
+	root, _ := ReplyToThread(db, user, thread.ID, "This is the first top level reply here")
+	child, childErr := ReplyToPost(db, user, thread.ID, root.ID, "[quote=" + fmt.Sprint(root.ID) + "]first[/quote] A reply to the top level reply")
+	if childErr != nil {
+		t.Error("Unexpected error replying to post", childErr)
+		return
+	}
+	ReplyToPost(db, user, thread.ID, child.ID, "A reply to the reply of the top level reply")
+
+	otherPost, _ := ReplyToThread(db, user, other.ID, "A reply in the unrelated other thread")
+	if _, err := ReplyToPost(db, user, thread.ID, otherPost.ID, "Parent belongs to a different thread"); err == nil {
+		t.Error("Expected error replying to a post from another thread")
+	}
+
+	if quoted := GetQuotedPostIds(db, child.ID); len(quoted) != 1 || quoted[0] != root.ID {
+		t.Error("Expected quote record for the quoted post: ", quoted)
+	}
+
+	var posts []Post
+	GetPostTree(db, nil, MakeTimestamp(), 10, thread.ID, 0, 2, &posts)
+	if len(posts) != 1 || len(posts[0].Replies) != 1 {
+		t.Error("Expected one top level post with one nested reply")
+	} else if len(posts[0].Replies[0].Replies) != 0 || !posts[0].Replies[0].HasMoreReplies {
+		t.Error("Expected replies past the depth limit to be flagged")
+	}
+
+	quoting := "[quote=" + fmt.Sprint(otherPost.ID) + "]elsewhere[/quote] [quote=" + fmt.Sprint(root.ID) + "]first[/quote]"
+	RecordQuotes(db, thread.ID, child.ID, quoting)
+	if quoted := GetQuotedPostIds(db, child.ID); len(quoted) != 1 || quoted[0] != root.ID {
+		t.Error("Expected posts from other threads not to be quotable: ", quoted)
+	}
+	RecordQuotes(db, thread.ID, child.ID, "The quote was edited out")
+	if quoted := GetQuotedPostIds(db, child.ID); len(quoted) != 0 {
+		t.Error("Expected the quote record to go when the quote is edited out: ", quoted)
+	}
+
+	DeletePost(db, user, root.ID)
+	posts = nil
+	GetPostTree(db, nil, MakeTimestamp(), 10, thread.ID, 0, 2, &posts)
+	if len(posts) != 1 || posts[0].ID != child.ID || len(posts[0].Replies) != 1 {
+		t.Error("Expected the reply to the deleted post to move up to the top level with its own replies")
+	}
+}
+
+func TestAddReaction(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	other, _ := FindUser(db, 2)
+	thread, _ := CreateThread(db, user, "Thread to react to here", "Reactions should be counted on this thread")
+
+	if err := AddReaction(db, user, TargetThread, thread.ID, "+1"); err != nil {
+		t.Error("Unexpected error adding reaction", err)
+	}
+	if err := AddReaction(db, user, TargetThread, thread.ID, "+1"); err == nil {
+		t.Error("Expected error adding the same reaction twice")
+	}
+	if err := AddReaction(db, user, TargetThread, thread.ID, "not-an-emoji"); err == nil {
+		t.Error("Expected error adding a reaction outside the allowed set")
+	}
+	AddReaction(db, other, TargetThread, thread.ID, "+1")
+
+	threads := []Thread{*thread}
+	LoadThreadReactions(db, user, threads)
+	if len(threads[0].Reactions) != 1 || threads[0].Reactions[0].Count != 2 {
+		t.Error("Expected two +1 reactions: ", threads[0].Reactions)
+	}
+	if len(threads[0].MyReactions) != 1 {
+		t.Error("Expected the viewer's own reaction: ", threads[0].MyReactions)
+	}
+}
+
+func TestRemoveReaction(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	if err := RemoveReaction(db, user, TargetThread, 1, "heart"); err == nil {
+		t.Error("Expected error removing a reaction that doesn't exist")
+	}
+}
+
+func TestCreateThreadWithPoll(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	other, _ := FindUser(db, 2)
+
+	badPoll := NewPoll{Question: "Only one option?", Options: []string{"Yes"}}
+	if _, err := CreateThreadWithPoll(db, user, "Thread with a bad poll", "This poll doesn't have enough options", &badPoll); err == nil {
+		t.Error("Expected error creating a poll with one option")
+	}
+
+	poll := NewPoll{Question: "Which one do you prefer?", Options: []string{"Tabs", "Spaces"}}
+	thread, err := CreateThreadWithPoll(db, user, "Thread with a poll on it", "Vote for your favourite option below", &poll)
+	if err != nil {
+		t.Error("Unexpected error creating thread with poll", err)
+		return
+	}
+
+	options := thread.Poll.Options
+	if err := VoteInPoll(db, user, thread.ID, []uint{options[0].ID, options[1].ID}); err == nil {
+		t.Error("Expected error voting for two options in a single choice poll")
+	}
+
+	if detail, _ := GetThreadDetail(db, user, thread.ID); detail.Poll.ResultsVisible {
+		t.Error("Expected results to be hidden before voting")
+	}
+
+	if err := VoteInPoll(db, user, thread.ID, []uint{options[0].ID}); err != nil {
+		t.Error("Unexpected error voting", err)
+	}
+	if err := VoteInPoll(db, user, thread.ID, []uint{options[1].ID}); err != nil {
+		t.Error("Unexpected error changing vote", err)
+	}
+	VoteInPoll(db, other, thread.ID, []uint{options[1].ID})
+
+	detail, _ := GetThreadDetail(db, user, thread.ID)
+	if !detail.Poll.ResultsVisible || *detail.Poll.Options[0].Votes != 0 || *detail.Poll.Options[1].Votes != 2 {
+		t.Error("Expected changed vote to be tallied")
+	}
+}
+
+func TestCreateAttachment(t *testing.T) {
+	root, _ := ioutil.TempDir("", "attachments")
+	defer os.RemoveAll(root)
+	store, _ := blobstore.NewLocalStore(root)
+
+	user, _ := FindUser(db, 1)
+	other, _ := FindUser(db, 2)
+	thread, _ := CreateThread(db, user, "Thread with an attachment", "There's an image attached to this thread")
+
+	var imageData bytes.Buffer
+	png.Encode(&imageData, image.NewRGBA(image.Rect(0, 0, 30, 20)))
+
+	if _, err := CreateAttachment(db, store, other, thread.ID, 0, "image.png", bytes.NewReader(imageData.Bytes())); err == nil {
+		t.Error("Expected error attaching to someone else's thread")
+	}
+
+	if _, err := CreateAttachment(db, store, user, thread.ID, 0, "script.sh", strings.NewReader("\x00\x01\x02 binary data")); err == nil {
+		t.Error("Expected error attaching an unsupported file type")
+	}
+
+	if _, err := CreateAttachment(db, store, user, thread.ID, 0, "../image.png", bytes.NewReader(imageData.Bytes())); err == nil {
+		t.Error("Expected error attaching a file named with a path")
+	}
+
+	attachment, err := CreateAttachment(db, store, user, thread.ID, 0, "image.png", bytes.NewReader(imageData.Bytes()))
+	if err != nil {
+		t.Error("Unexpected error creating attachment", err)
+		return
+	}
+	if attachment.MimeType != "image/png" || attachment.Width != 30 || attachment.Height != 20 {
+		t.Error("Expected sniffed type and image dimensions: ", attachment)
+	}
+
+	if _, err := FindAttachment(db, nil, attachment.ID); err != nil {
+		t.Error("Expected attachment to be found", err)
+	}
+	DeleteThread(db, user, thread.ID)
+	if _, err := FindAttachment(db, nil, attachment.ID); err == nil {
+		t.Error("Expected attachment of a deleted thread to not be found")
+	}
+}
+
+func TestStartConversation(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	other, _ := FindUser(db, 2)
+
+	if _, err := StartConversation(db, user, []uint{other.ID}, "short"); err == nil {
+		t.Error("Expected error starting a conversation with content that's too short")
+	}
+
+	conversation, err := StartConversation(db, user, []uint{other.ID}, "Hey there, how's it going with you?")
+	if err != nil {
+		t.Error("Unexpected error starting conversation", err)
+		return
+	}
+	for _, participant := range conversation.Participants {
+		if participant.User.ID != participant.UserID || participant.User.Username == "" {
+			t.Error("Expected the participants to come back with their users: ", participant)
+		}
+	}
+
+	if CountUnreadMessages(db, other) != 1 {
+		t.Error("Expected one unread message for the other participant")
+	}
+	var conversations []Conversation
+	GetConversations(db, other, MakeTimestamp() + 1, 10, &conversations)
+	if len(conversations) != 1 || conversations[0].UnreadCount != 1 {
+		t.Error("Expected the conversation to show one unread message: ", conversations)
+	}
+
+	if _, err := SendMessage(db, other, conversation.ID, "Not bad at all, thanks for asking me"); err != nil {
+		t.Error("Unexpected error replying", err)
+	}
+	MarkConversationRead(db, other, conversation.ID)
+	if CountUnreadMessages(db, other) != 0 {
+		t.Error("Expected no unread messages after marking as read")
+	}
+
+	var messages []Message
+	GetMessages(db, user, conversation.ID, MakeTimestamp() + 1, 10, &messages)
+	if len(messages) != 2 {
+		t.Error("Expected two messages in the conversation")
+	}
+
+	BlockUser(db, other, user.ID)
+	if _, err := SendMessage(db, user, conversation.ID, "This one shouldn't get through to you"); err == nil {
+		t.Error("Expected error messaging a user that has blocked you")
+	}
+	UnblockUser(db, other, user.ID)
+
+	LeaveConversation(db, other, conversation.ID)
+	if err := GetMessages(db, other, conversation.ID, MakeTimestamp(), 10, &messages); err == nil {
+		t.Error("Expected error reading a conversation after leaving")
+	}
+}
+
+func TestSaveDraft(t *testing.T) {
+	user, _ := FindUser(db, 1)
+
+	SaveDraft(db, user, 0, "Half written", "Not done yet")
+	if _, err := SaveDraft(db, user, 0, "Half written title", "Still not done yet"); err != nil {
+		t.Error("Unexpected error saving draft", err)
+	}
+
+	var drafts []Draft
+	GetDrafts(db, user, MakeTimestamp() + 1, 10, &drafts)
+	if len(drafts) != 1 || drafts[0].Title != "Half written title" {
+		t.Error("Expected saving the same context to replace the draft: ", drafts)
+	}
+
+	if _, err := CreateThread(db, user, "Half written title", "Finally finished writing this thread"); err != nil {
+		t.Error("Unexpected error creating thread", err)
+	}
+	if _, err := FindDraft(db, user, 0); err == nil {
+		t.Error("Expected draft to be removed once published")
+	}
+}
+
+func TestPurgeExpiredDrafts(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	DraftExpiry = -time.Hour
+	defer func() { DraftExpiry = time.Hour * 24 * 30 }()
+
+	SaveDraft(db, user, 0, "Expired draft", "This draft expired already")
+	if _, err := FindDraft(db, user, 0); err == nil {
+		t.Error("Expected expired draft to not be found")
+	}
+	if PurgeExpiredDrafts(db) < 1 {
+		t.Error("Expected expired draft to be purged")
+	}
+}
+
+func TestLoadUnreadCounts(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	reader, _ := FindUser(db, 2)
+	thread, _ := CreateThread(db, user, "Thread to keep track of", "Replies here should show up as unread")
+	first, _ := ReplyToThread(db, user, thread.ID, "The first reply that hasn't been read")
+	ReplyToThread(db, user, thread.ID, "The second reply that hasn't been read")
+
+	threads := []Thread{*thread}
+	LoadUnreadCounts(db, reader, threads)
+	if *threads[0].UnreadCount != 2 || *threads[0].FirstUnreadPostID != first.ID {
+		t.Error("Expected two unread posts starting at the first reply")
+	}
+
+	var posts []Post
+	GetPostsForThread(db, MakeTimestamp() + 1, 1, thread.ID, &posts)
+	MarkPostsRead(db, reader, thread.ID, posts)
+	LoadUnreadCounts(db, reader, threads)
+	if *threads[0].UnreadCount != 1 {
+		t.Error("Expected one unread post after reading the first")
+	}
+
+	MarkAllRead(db, reader)
+	LoadUnreadCounts(db, reader, threads)
+	if *threads[0].UnreadCount != 0 || threads[0].FirstUnreadPostID != nil {
+		t.Error("Expected no unread posts after marking everything read")
+	}
+}
+
+func TestCompactReadPositions(t *testing.T) {
+	reader, _ := FindUser(db, 2)
+	MaxReadPositions = 2
+	defer func() { MaxReadPositions = 1000 }()
+
+	for i := 1; i <= 3; i++ {
+		MarkThreadRead(db, reader, uint(i), MakeTimestamp() + int64(i))
+	}
+
+	var count int
+	db.Model(&ReadPosition{}).Where("user_id = ?", reader.ID).Count(&count)
+	if count > MaxReadPositions {
+		t.Error("Expected read positions to be collapsed into the watermark")
+	}
+}
+
+func TestAddBookmark(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	thread, _ := CreateThread(db, user, "Thread worth saving for later", "This thread is going to be bookmarked")
+	post, _ := ReplyToThread(db, user, thread.ID, "This post is going to be bookmarked too")
+
+	if _, err := AddBookmark(db, user, TargetThread, thread.ID, "read later", "reading"); err != nil {
+		t.Error("Unexpected error bookmarking thread", err)
+	}
+	if _, err := AddBookmark(db, user, TargetThread, thread.ID, "", ""); err == nil {
+		t.Error("Expected error bookmarking the same thread twice")
+	}
+	AddBookmark(db, user, TargetPost, post.ID, "", "")
+	DeletePost(db, user, post.ID)
+
+	var bookmarks []Bookmark
+	GetBookmarks(db, user, "", MakeTimestamp() + 1, 10, &bookmarks)
+	if len(bookmarks) < 2 || !bookmarks[0].Deleted || bookmarks[0].Post != nil {
+		t.Error("Expected a tombstone for the deleted post")
+	}
+	if bookmarks[1].Deleted || bookmarks[1].Thread == nil || bookmarks[1].Note != "read later" {
+		t.Error("Expected the thread bookmark with its note")
+	}
+
+	var folder []Bookmark
+	GetBookmarks(db, user, "reading", MakeTimestamp() + 1, 10, &folder)
+	if len(folder) != 1 {
+		t.Error("Expected one bookmark in the folder")
+	}
+
+	if export := ExportUserData(db, user); len(export.Bookmarks) < 2 {
+		t.Error("Expected bookmarks in the data export")
+	}
+}
+
+func TestGetThreadsSorted(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	other, _ := FindUser(db, 2)
+	quiet, _ := CreateThread(db, other, "A quiet thread with no replies", "Nobody is going to reply to this thread")
+	busy, _ := CreateThread(db, other, "A busy thread with replies", "Everybody is going to reply to this thread")
+	ReplyToThread(db, user, busy.ID, "The first of the replies to the busy thread")
+	ReplyToThread(db, user, busy.ID, "The second of the replies to the busy thread")
+
+	var newest []Thread
+	GetThreads(db, nil, ThreadQuery{Sort: SortNewest, Limit: 1}, &newest)
+	if len(newest) != 1 || newest[0].ID != busy.ID {
+		t.Error("Expected the most recently created thread first")
+	}
+
+	var replies []Thread
+	cursor, _ := GetThreads(db, nil, ThreadQuery{Sort: SortReplies, Limit: 1}, &replies)
+	if len(replies) != 1 || replies[0].PostsCount < 2 || cursor == "" {
+		t.Error("Expected the thread with the most replies first, with a cursor for the next page")
+	}
+
+	var nextPage []Thread
+	GetThreads(db, nil, ThreadQuery{Sort: SortReplies, Limit: 1, Cursor: cursor}, &nextPage)
+	if len(nextPage) != 1 || nextPage[0].ID == replies[0].ID || nextPage[0].PostsCount > replies[0].PostsCount {
+		t.Error("Expected the cursor to continue after the first page")
+	}
+
+	// Reactions from before the window don't count towards "top" within it
+	AddReaction(db, user, TargetThread, quiet.ID, "heart")
+	AddReaction(db, other, TargetThread, quiet.ID, "eyes")
+	db.Model(&Reaction{}).Where("target_type = ? AND target_id = ?", TargetThread, quiet.ID).
+			UpdateColumn("timestamp", MakeTimestamp() - int64(time.Hour * 24 * 10 / time.Millisecond))
+	AddReaction(db, user, TargetThread, busy.ID, "heart")
+
+	topRank := func(window string) (int, int) {
+		var top []Thread
+		GetThreads(db, nil, ThreadQuery{Sort: SortTop, Window: window, Limit: 100}, &top)
+		quietRank, busyRank := -1, -1
+		for i, thread := range top {
+			if thread.ID == quiet.ID {
+				quietRank = i
+			} else if thread.ID == busy.ID {
+				busyRank = i
+			}
+		}
+		return quietRank, busyRank
+	}
+	if quietRank, busyRank := topRank("week"); busyRank < 0 || quietRank >= 0 && quietRank < busyRank {
+		t.Error("Expected only this week's reactions to rank threads in the weekly top")
+	}
+	if quietRank, busyRank := topRank("all"); quietRank < 0 || busyRank >= 0 && busyRank < quietRank {
+		t.Error("Expected every reaction to count in the all time top")
+	}
+
+	var topPage []Thread
+	topCursor, _ := GetThreads(db, nil, ThreadQuery{Sort: SortTop, Window: "week", Limit: 1}, &topPage)
+	if _, _, since, valid := decodeCursor(topCursor); !valid || since <= 0 || since > MakeTimestamp() - int64(time.Hour * 24 * 6 / time.Millisecond) {
+		t.Error("Expected the weekly top cursor to carry where the window started, got ", since)
+	}
+	var topNext []Thread
+	if _, err := GetThreads(db, nil, ThreadQuery{Sort: SortTop, Window: "week", Limit: 1, Cursor: topCursor}, &topNext); err != nil ||
+			len(topNext) == 1 && topNext[0].ID == topPage[0].ID {
+		t.Error("Expected the next weekly top page to continue from the anchored window, got ", err)
+	}
+
+	var hot []Thread
+	GetThreads(db, nil, ThreadQuery{Sort: SortHot, Limit: 100}, &hot)
+	for _, thread := range hot {
+		if thread.ID == quiet.ID {
+			break
+		}
+		if thread.ID == busy.ID {
+			return
+		}
+	}
+	t.Error("Expected the busy thread to be hotter than the quiet one")
+}
+
+func TestGetThreadsBlocked(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	BlockUser(db, user, 2)
+	defer UnblockUser(db, user, 2)
+
+	for _, sort := range []string{SortNewest, SortActive, SortReplies, SortTop, SortHot} {
+		var threads []Thread
+		GetThreads(db, user, ThreadQuery{Sort: sort, Window: "all", Limit: 100}, &threads)
+		for _, thread := range threads {
+			var authors []User
+			db.Model(&thread).Association("Authors").Find(&authors)
+			if len(authors) > 0 && authors[0].ID == 2 {
+				t.Error("Expected blocked user's threads to be hidden when sorting by ", sort)
+			}
+		}
+	}
+
+	var threads []Thread
+	if _, err := GetThreads(db, user, ThreadQuery{Sort: "sideways", Limit: 10}, &threads); err == nil {
+		t.Error("Expected error for an unknown sort")
+	}
+}
+
 func TestGetUsers(t *testing.T) {
 	var users []User
 	GetUsers(db, &users)
 	if len(users) < 1 {
 		t.Error("Expected more than 0 users")
 	}
-}
\ No newline at end of file
+}
+func TestSpamFilter(t *testing.T) {
+	SpamPipeline = spam.DefaultPipeline([]string{"spam.example"})
+	defer func() { SpamPipeline = nil }()
+
+	// A new account that hasn't posted anything, so only the signals under test add to the score
+	CreateUser(db, "freshaccount", "freshpassword1")
+	user, _ := FindUserByUsername(db, "freshaccount")
+	other, _ := FindUser(db, 2)
+
+	if _, err := CreateThread(db, user, "Great deals on everything", "Take a look at https://shop.spam.example right now"); err != errors.ErrSpam {
+		t.Error("Expected content linking to a blocked domain to be rejected")
+	}
+
+	thread, err := CreateThread(db, user, "Links worth a look", "https://a.example https://b.example https://c.example https://d.example")
+	if err != nil || !thread.Held {
+		t.Fatal("Expected link heavy content from a new account to be held")
+	}
+
+	if _, err := ReplyToThread(db, other, thread.ID, "Can anyone else see this thread yet?"); err == nil {
+		t.Error("Expected other users not to be able to reply to a held thread")
+	}
+
+	isVisible := func(viewer *User) bool {
+		var threads []Thread
+		GetThreads(db, viewer, ThreadQuery{Sort: SortNewest, Limit: 100}, &threads)
+		for _, visible := range threads {
+			if visible.ID == thread.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	if isVisible(nil) || isVisible(other) {
+		t.Error("Expected held thread to be hidden from everyone else")
+	}
+	if !isVisible(user) {
+		t.Error("Expected held thread to be visible to its author")
+	}
+
+	other.Role = RoleModerator
+	if !isVisible(other) {
+		t.Error("Expected held thread to be visible to moderators")
+	}
+
+	var threads []Thread
+	var posts []Post
+	GetHeldContent(db, MakeTimestamp() + 1, 10, &threads, &posts)
+	if len(threads) != 1 || threads[0].ID != thread.ID {
+		t.Error("Expected held thread in the moderation queue")
+	}
+
+	if err := ApproveThread(db, other, thread.ID); err != nil || !isVisible(nil) {
+		t.Error("Expected approved thread to be visible to everyone")
+	}
+}
+
+func TestHeldContentHidden(t *testing.T) {
+	SpamPipeline = spam.DefaultPipeline(nil)
+	defer func() { SpamPipeline = nil }()
+
+	root, _ := ioutil.TempDir("", "attachments")
+	defer os.RemoveAll(root)
+	store, _ := blobstore.NewLocalStore(root)
+
+	CreateUser(db, "heldaccount", "heldpassword1")
+	user, _ := FindUserByUsername(db, "heldaccount")
+	other, _ := FindUser(db, 2)
+
+	thread, err := CreateThread(db, user, "More links worth a look", "https://e.example https://f.example https://g.example https://h.example")
+	if err != nil || !thread.Held {
+		t.Fatal("Expected link heavy content from a new account to be held")
+	}
+	var imageData bytes.Buffer
+	png.Encode(&imageData, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+	attachment, attachErr := CreateAttachment(db, store, user, thread.ID, 0, "image.png", bytes.NewReader(imageData.Bytes()))
+	if attachErr != nil {
+		t.Fatal("Unexpected error attaching to a held thread", attachErr)
+	}
+
+	if err := AddReaction(db, other, TargetThread, thread.ID, "heart"); err != errors.ErrNotExist {
+		t.Error("Expected reacting to a held thread to fail as not found, got ", err)
+	}
+	if _, err := AddBookmark(db, other, TargetThread, thread.ID, "", ""); err != errors.ErrNotExist {
+		t.Error("Expected bookmarking a held thread to fail as not found, got ", err)
+	}
+	if _, err := FindAttachment(db, other, attachment.ID); err != errors.ErrNotExist {
+		t.Error("Expected an attachment on a held thread not to be found, got ", err)
+	}
+	if _, err := FindAttachment(db, nil, attachment.ID); err != errors.ErrNotExist {
+		t.Error("Expected an attachment on a held thread not to be found by guests, got ", err)
+	}
+
+	if _, err := FindAttachment(db, user, attachment.ID); err != nil {
+		t.Error("Expected the author to still see their attachment, got ", err)
+	}
+	if err := AddReaction(db, user, TargetThread, thread.ID, "heart"); err != nil {
+		t.Error("Expected the author to still be able to react, got ", err)
+	}
+}
+
+func TestTrustLevels(t *testing.T) {
+	CreateUser(db, "trustnewbie", "trustpassword1")
+	user, _ := FindUserByUsername(db, "trustnewbie")
+	other, _ := FindUser(db, 2)
+	thread, _ := CreateThread(db, other, "Introduce yourself here", "New members can say hello in this thread")
+
+	TrustLevels = DefaultTrustLevels
+	PostRateLimit = 10
+	defer disableAbuseChecks()
+
+	if _, err := CreateThread(db, user, "My very first thread", "Hello everyone, I just joined"); err != errors.ErrTrustLevel {
+		t.Error("Expected new users not to be able to create threads")
+	}
+
+	if _, err := ReplyToThread(db, user, thread.ID, "Have a look at https://example.com for more"); err != errors.ErrTrustLevel {
+		t.Error("Expected new users not to be able to post links")
+	}
+	if _, err := ReplyToThread(db, user, thread.ID, "Have a look at www.example.com for more"); err != errors.ErrTrustLevel {
+		t.Error("Expected new users not to be able to post bare links either")
+	}
+
+	post, err := ReplyToThread(db, user, thread.ID, "Hello everyone, I just joined")
+	if err != nil {
+		t.Fatal("Expected new users to be able to reply: ", err)
+	}
+
+	FlagContent(db, other, TargetPost, post.ID, "Not a great introduction")
+	if err := FlagContent(db, user, TargetPost, post.ID, "Flagging myself"); err == nil {
+		t.Error("Expected users not to be able to flag their own posts")
+	}
+
+	progress := GetTrustProgress(db, user)
+	if progress.Level.Level != 0 || progress.Next == nil || progress.Posts != 1 || progress.FlagsReceived != 1 {
+		t.Error("Expected new user progress towards the next level: ", progress)
+	}
+
+	level := 2
+	SetTrustLevelOverride(db, other, user.ID, &level)
+	user, _ = FindUser(db, user.ID)
+	if progress := GetTrustProgress(db, user); progress.Level.Level != 2 {
+		t.Error("Expected the override to set the trust level")
+	}
+	if _, err := CreateThread(db, user, "My very first thread", "Now I can start threads of my own"); err != nil {
+		t.Error("Expected overridden user to be able to create threads: ", err)
+	}
+}
+
+func TestBans(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	moderator, _ := FindUser(db, 2)
+
+	if _, err := IssueBan(db, moderator, BanUser, user.ID, "", "Spamming", time.Hour); err != errors.ErrForbidden {
+		t.Error("Expected users without the moderator role to be stopped in the database layer too")
+	}
+	moderator.Role = RoleModerator
+
+	ban, err := IssueBan(db, moderator, BanUser, user.ID, "", "Spamming", time.Hour)
+	if err != nil {
+		t.Fatal("Expected suspension to be issued: ", err)
+	}
+	if active := FindActiveBan(db, user); active == nil || active.UserError() != errors.ErrSuspended || active.Reason != "Spamming" {
+		t.Error("Expected the user to be suspended")
+	}
+
+	LiftBan(db, moderator, ban.ID)
+	if FindActiveBan(db, user) != nil {
+		t.Error("Expected the lifted suspension to no longer apply")
+	}
+
+	IssueBan(db, moderator, BanIP, 0, "10.0.0.0/8", "Bot network", 0)
+	IssueBan(db, moderator, BanUsername, 0, "spam.*", "Spam accounts", 0)
+	if err := CheckRegistrationBans(db, "regularname", "10.1.2.3"); err != errors.ErrBanned {
+		t.Error("Expected registrations from a banned range to be refused")
+	}
+	if err := CheckRegistrationBans(db, "SpamBot", "192.168.0.1"); err != errors.ErrBanned {
+		t.Error("Expected usernames matching a banned pattern to be refused")
+	}
+	if err := CheckRegistrationBans(db, "regularname", "192.168.0.1"); err != nil {
+		t.Error("Expected other registrations to be allowed")
+	}
+
+	var bans []Ban
+	GetBans(db, MakeTimestamp() + 1, 10, true, &bans)
+	if len(bans) != 2 {
+		t.Error("Expected only the bans still in force")
+	}
+}
+
+func TestShadowBan(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	moderator, _ := FindUser(db, 2)
+	moderator.Role = RoleModerator
+
+	thread, _ := CreateThread(db, moderator, "Thread a spammer finds", "Replies from the spammer shouldn't show up")
+	SetShadowBanned(db, moderator, user.ID, true)
+	user, _ = FindUser(db, user.ID)
+
+	post, err := ReplyToThread(db, user, thread.ID, "Buy things from my shop")
+	if err != nil {
+		t.Fatal("Expected shadow banned users to be able to post as normal: ", err)
+	}
+
+	if thread, _ := FindThread(db, thread.ID); thread.PostsCount != 0 || thread.LastUpdate != thread.Timestamp {
+		t.Error("Expected the shadowed post not to bump the thread")
+	}
+
+	countVisible := func(viewer *User) int {
+		var posts []Post
+		GetPostsForThreadForUser(db, viewer, MakeTimestamp() + 1, 10, thread.ID, &posts)
+		return len(posts)
+	}
+
+	moderator.Role = RoleUser
+	if countVisible(nil) != 0 || countVisible(moderator) != 0 {
+		t.Error("Expected the shadowed post to be hidden from everyone else")
+	}
+	if countVisible(user) != 1 {
+		t.Error("Expected the shadowed post to be visible to its author")
+	}
+
+	countFound := func(viewer *User) int {
+		var threads []Thread
+		var posts []Post
+		Search(db, viewer, "from my shop", MakeTimestamp() + 1, 10, &threads, &posts)
+		return len(posts)
+	}
+	if countFound(nil) != 0 || countFound(moderator) != 0 || countFound(user) != 1 {
+		t.Error("Expected the shadowed post to only be found by its author")
+	}
+	if err := AddReaction(db, moderator, TargetPost, post.ID, "heart"); err != errors.ErrNotExist {
+		t.Error("Expected reacting to a shadowed post to fail as not found, got ", err)
+	}
+	if _, err := AddBookmark(db, moderator, TargetPost, post.ID, "", ""); err != errors.ErrNotExist {
+		t.Error("Expected bookmarking a shadowed post to fail as not found, got ", err)
+	}
+
+	moderator.Role = RoleModerator
+	if countVisible(moderator) != 1 {
+		t.Error("Expected the shadowed post to be visible to moderators")
+	}
+
+	SetShadowBanned(db, moderator, user.ID, false)
+	DeletePost(db, user, post.ID)
+}
+
+func TestAuditLog(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	thread, _ := CreateThread(db, user, "Thread that gets deleted", "The audit log should remember this thread")
+
+	DeleteThread(db.Set(AuditIPKey, "203.0.113.7"), user, thread.ID)
+
+	var entries []AuditEntry
+	GetAuditLog(db, AuditQuery{Action: AuditDeleteThread, TargetID: thread.ID, Timestamp: MakeTimestamp() + 1, Limit: 10}, &entries)
+	if len(entries) != 1 {
+		t.Fatal("Expected the deletion to be in the audit log")
+	}
+
+	entry := entries[0]
+	if entry.Actor.ID != user.ID || entry.IP != "203.0.113.7" || !strings.Contains(entry.Before, "The audit log should remember this thread") {
+		t.Error("Expected the actor, IP and deleted content to be recorded: ", entry)
+	}
+
+	entry.Action = "something_else"
+	if err := db.Save(&entry).Error; err == nil {
+		t.Error("Expected audit entries not to be editable")
+	}
+	if err := db.Model(&entry).UpdateColumn("action", "something_else").Error; err == nil {
+		t.Error("Expected audit entries not to be editable with UpdateColumn")
+	}
+	if err := db.Table("audit_entries").Where("id = ?", entry.ID).UpdateColumns(map[string]interface{}{"ip": ""}).Error; err == nil {
+		t.Error("Expected audit entries not to be editable through the table")
+	}
+	if err := db.Delete(&entry).Error; err == nil {
+		t.Error("Expected audit entries not to be deletable outside of purging")
+	}
+	GetAuditLog(db, AuditQuery{Action: AuditDeleteThread, TargetID: thread.ID, Timestamp: MakeTimestamp() + 1, Limit: 10}, &entries)
+	if len(entries) != 1 || entries[0].IP != "203.0.113.7" {
+		t.Error("Expected the entry to be unchanged: ", entries)
+	}
+
+	AuditRetention = 0
+	defer func() { AuditRetention = time.Hour * 24 * 365 }()
+	PurgeAuditLog(db)
+	GetAuditLog(db, AuditQuery{Timestamp: MakeTimestamp() + 1, Limit: 10}, &entries)
+	if len(entries) != 0 {
+		t.Error("Expected entries past the retention period to be purged")
+	}
+}
+
+func TestRestoreAndPurge(t *testing.T) {
+	user, _ := FindUser(db, 1)
+	other, _ := FindUser(db, 2)
+	thread, _ := CreateThread(db, user, "Thread deleted by mistake", "This thread is going to come back")
+	post, _ := ReplyToThread(db, user, thread.ID, "This post is going to be purged")
+
+	DeleteThread(db, user, thread.ID)
+	DeletePost(db, user, post.ID)
+
+	var threads []Thread
+	var posts []Post
+	GetTrash(db, user, MakeTimestamp() + 1, 10, &threads, &posts)
+	if len(threads) != 1 || threads[0].ID != thread.ID || len(posts) != 1 || posts[0].ID != post.ID {
+		t.Error("Expected the deleted thread and post in the trash")
+	}
+
+	if err := RestoreThread(db, other, thread.ID); err == nil {
+		t.Error("Expected other users not to be able to restore the thread")
+	}
+	if err := RestoreThread(db, user, thread.ID); err != nil {
+		t.Error("Expected the author to be able to restore the thread: ", err)
+	}
+	if _, err := FindThread(db, thread.ID); err != nil {
+		t.Error("Expected the restored thread to be back")
+	}
+
+	TrashRetention = 0
+	defer func() { TrashRetention = time.Hour * 24 * 30 }()
+	time.Sleep(time.Millisecond * 2)
+	root, _ := ioutil.TempDir("", "attachments")
+	defer os.RemoveAll(root)
+	store, _ := blobstore.NewLocalStore(root)
+	if err := PurgeDeletedContent(db, store); err != nil {
+		t.Error("Unexpected error purging: ", err)
+	}
+
+	var count int64
+	db.Model(&Post{}).Where("id = ?", post.ID).Count(&count)
+	if count != 0 {
+		t.Error("Expected the deleted post to be purged")
+	}
+	db.Table("thread_posts").Where("post_id = ?", post.ID).Count(&count)
+	if count != 0 {
+		t.Error("Expected the purged post's join rows to be removed")
+	}
+	db.Model(&AuditEntry{}).Where("action = ? AND target_type = ? AND target_id = ? AND actor_id = ?", AuditPurgePost, TargetPost, post.ID, 0).Count(&count)
+	if count != 1 {
+		t.Error("Expected the purge to be recorded in the audit log")
+	}
+	if _, err := FindThread(db, thread.ID); err != nil {
+		t.Error("Expected the restored thread to be left alone")
+	}
+}
+
+func TestPurgeRemovesDependents(t *testing.T) {
+	root, _ := ioutil.TempDir("", "attachments")
+	defer os.RemoveAll(root)
+	store, _ := blobstore.NewLocalStore(root)
+
+	user, _ := FindUser(db, 1)
+	other, _ := FindUser(db, 2)
+	thread, _ := CreateThread(db, user, "Thread with a post to purge", "One of the replies here is going away for good")
+	post, _ := ReplyToThread(db, user, thread.ID, "This post has an attachment, a reaction, a bookmark and a reply")
+	reply, _ := ReplyToPost(db, other, thread.ID, post.ID, "Replying to the post that gets purged")
+
+	var imageData bytes.Buffer
+	png.Encode(&imageData, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+	attachment, err := CreateAttachment(db, store, user, 0, post.ID, "image.png", bytes.NewReader(imageData.Bytes()))
+	if err != nil {
+		t.Fatal("Unexpected error creating attachment", err)
+	}
+	AddReaction(db, other, TargetPost, post.ID, helpers.AllowedReactions[0])
+	AddBookmark(db, other, TargetPost, post.ID, "", "")
+	FlagContent(db, other, TargetPost, post.ID, "spam")
+
+	before, _ := FindUser(db, user.ID)
+	DeletePost(db, user, post.ID)
+
+	TrashRetention = 0
+	defer func() { TrashRetention = time.Hour * 24 * 30 }()
+	time.Sleep(time.Millisecond * 2)
+	if err := PurgeDeletedContent(db, store); err != nil {
+		t.Fatal("Unexpected error purging: ", err)
+	}
+
+	var count int64
+	for _, table := range []string{"posts", "thread_posts", "user_posts", "mentions", "notifications", "quotes", "attachments"} {
+		column := "post_id"
+		if table == "posts" {
+			column = "id"
+		}
+		db.Table(table).Where(column + " = ?", post.ID).Count(&count)
+		if count != 0 {
+			t.Error("Expected no rows left in " + table)
+		}
+	}
+	for _, table := range []string{"reactions", "bookmarks", "flags"} {
+		db.Table(table).Where("target_type = ? AND target_id = ?", TargetPost, post.ID).Count(&count)
+		if count != 0 {
+			t.Error("Expected no rows left in " + table)
+		}
+	}
+	if _, err := store.Get(attachment.Key); err == nil {
+		t.Error("Expected the attachment blob to be removed")
+	}
+
+	orphan, _ := FindPost(db, reply.ID)
+	if orphan == nil || orphan.ParentPostID != 0 {
+		t.Error("Expected the reply to move to the top level: ", orphan)
+	}
+	after, _ := FindUser(db, user.ID)
+	if after.PostsCount != before.PostsCount - 1 {
+		t.Error("Expected the author's post count to drop once: ", before.PostsCount, after.PostsCount)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	var wait sync.WaitGroup
+	results := make(chan *errors.UserError, 5)
+	for i := 0; i < 5; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			results <- CreateUser(db, "racingname", "racingpassword1")
+		}()
+	}
+	wait.Wait()
+	close(results)
+
+	created := 0
+	for err := range results {
+		if err == nil {
+			created++
+		} else if err != errors.ErrExists {
+			t.Error("Expected duplicate registrations to fail with ErrExists: ", err)
+		}
+	}
+	if created != 1 {
+		t.Error("Expected exactly one of the concurrent registrations to succeed")
+	}
+
+	user, _ := FindUser(db, 1)
+	thread, _ := CreateThread(db, user, "Thread replied to all at once", "Every reply should be counted")
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			ReplyToThread(db, user, thread.ID, fmt.Sprintf("Concurrent reply number %d", i))
+		}(i)
+	}
+	wait.Wait()
+
+	if thread, _ := FindThread(db, thread.ID); thread.PostsCount != 10 {
+		t.Error("Expected every concurrent reply to be counted, got ", thread.PostsCount)
+	}
+
+	pollThread, _ := CreateThreadWithPoll(db, user, "Thread voted on all at once", "Only one of these votes should stick", &NewPoll{Question: "Which one?", Options: []string{"First", "Second"}})
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			VoteInPoll(db, user, pollThread.ID, []uint{pollThread.Poll.Options[i % 2].ID})
+		}(i)
+	}
+	wait.Wait()
+
+	var votes int
+	db.Model(&PollVote{}).Where("poll_id = ? AND user_id = ?", pollThread.Poll.ID, user.ID).Count(&votes)
+	if votes != 1 {
+		t.Error("Expected concurrent votes in a single choice poll to leave one vote, got ", votes)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	author, _ := FindUser(db, 1)
+	replier, _ := FindUser(db, 2)
+	thread, _ := CreateThread(db, author, "Thread with counted replies", "Counters should follow deletes and restores")
+	post, _ := ReplyToThread(db, replier, thread.ID, "A reply that gets deleted")
+	ReplyToThread(db, author, thread.ID, "A reply that stays")
+
+	if thread, _ := FindThread(db, thread.ID); thread.PostsCount != 2 || thread.ParticipantsCount != 2 {
+		t.Error("Expected 2 posts and 2 participants, got ", thread.PostsCount, thread.ParticipantsCount)
+	}
+
+	postsBefore := replier.PostsCount
+	if replier, _ := FindUser(db, 2); replier.PostsCount != postsBefore + 1 {
+		t.Error("Expected the reply to count towards its author's posts")
+	}
+
+	DeletePost(db, replier, post.ID)
+	if thread, _ := FindThread(db, thread.ID); thread.PostsCount != 1 || thread.ParticipantsCount != 1 {
+		t.Error("Expected deleting a post to decrement the counters, got ", thread.PostsCount, thread.ParticipantsCount)
+	}
+	if count := CountPostsForThread(db, thread.ID); count != 1 {
+		t.Error("Expected deleted posts not to be counted, got ", count)
+	}
+
+	RestorePost(db, replier, post.ID)
+	if thread, _ := FindThread(db, thread.ID); thread.PostsCount != 2 || thread.ParticipantsCount != 2 {
+		t.Error("Expected restoring a post to increment the counters, got ", thread.PostsCount, thread.ParticipantsCount)
+	}
+
+	db.Model(&Thread{}).Where("id = ?", thread.ID).UpdateColumn("posts_count", 40)
+	if _, err := ReconcileCounters(db); err != nil {
+		t.Error("Unexpected error reconciling: ", err)
+	}
+	drifts, _ := ReconcileCounters(db)
+	if len(drifts) != 0 {
+		t.Error("Expected reconciling twice to leave nothing to fix: ", drifts)
+	}
+	if thread, _ := FindThread(db, thread.ID); thread.PostsCount != 2 {
+		t.Error("Expected reconciliation to fix the posts count, got ", thread.PostsCount)
+	}
+}
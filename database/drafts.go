@@ -0,0 +1,83 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/errors"
+	"time"
+)
+
+var (
+	DraftExpiry = time.Hour * 24 * 30
+)
+
+// An unsent thread (ThreadID 0) or reply to ThreadID, one per user for each context
+type Draft struct {
+	BaseModel
+	UserID uint `json:"-"`
+	ThreadID uint `json:"threadId"`
+	Title string `json:"title"`
+	Content string `json:"content" gorm:"type:text"`
+	LastUpdate int64 `json:"lastUpdate"`
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// Saves the user's draft for a context, replacing whatever was saved there before
+func SaveDraft(db *gorm.DB, user *User, threadId uint, title string, content string) (*Draft, *errors.UserError) {
+
+	if threadId > 0 {
+		if _, err := FindVisibleThread(db, user, threadId); err != nil {
+			return nil, err
+		}
+	}
+
+	timestamp := MakeTimestamp()
+	var draft Draft
+	db.Where("user_id = ? AND thread_id = ?", user.ID, threadId).First(&draft)
+
+	draft.UserID = user.ID
+	draft.ThreadID = threadId
+	draft.Title = title
+	draft.Content = content
+	draft.LastUpdate = timestamp
+	draft.ExpiresAt = timestamp + int64(DraftExpiry / time.Millisecond)
+
+	if err := db.Save(&draft).Error; err != nil {
+		return nil, errors.ErrSystem
+	}
+	return &draft, nil
+
+}
+
+// Gets the user's draft for a context if it hasn't expired
+func FindDraft(db *gorm.DB, user *User, threadId uint) (*Draft, *errors.UserError) {
+	var draft Draft
+	db.Where("user_id = ? AND thread_id = ? AND expires_at > ?", user.ID, threadId, MakeTimestamp()).First(&draft)
+	if draft.ID > 0 {
+		return &draft, nil
+	} else {
+		return nil, errors.ErrNotExist
+	}
+}
+
+// Gets the user's drafts that haven't expired, most recently saved first
+func GetDrafts(db *gorm.DB, user *User, timestamp int64, limit int, drafts *[]Draft) {
+	db.Order("last_update desc").Limit(limit).Where("user_id = ? AND last_update < ? AND expires_at > ?", user.ID, timestamp, MakeTimestamp()).Find(drafts)
+}
+
+// Deletes the user's draft for a context
+func DeleteDraft(db *gorm.DB, user *User, threadId uint) *errors.UserError {
+	if _, err := FindDraft(db, user, threadId); err != nil {
+		return err
+	}
+	deleteDraft(db, user, threadId)
+	return nil
+}
+
+func deleteDraft(db *gorm.DB, user *User, threadId uint) error {
+	return db.Where("user_id = ? AND thread_id = ?", user.ID, threadId).Delete(&Draft{}).Error
+}
+
+// Removes every draft past its expiry
+func PurgeExpiredDrafts(db *gorm.DB) int64 {
+	return db.Where("expires_at <= ?", MakeTimestamp()).Delete(&Draft{}).RowsAffected
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/errors"
+	"ForumDatabase/helpers"
+)
+
+// A user reporting a thread or post, counted against its author's trust level
+type Flag struct {
+	BaseModel
+	UserID uint `json:"-"`
+	AuthorID uint `json:"authorId"`
+	TargetType string `json:"targetType"`
+	TargetID uint `json:"targetId"`
+	Reason string `json:"reason"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Gets the id of the user who wrote a thread or post
+func findTargetAuthor(db *gorm.DB, targetType string, targetID uint) uint {
+	var authorIDs []uint
+	if targetType == TargetThread {
+		db.Table("user_threads").Where("thread_id = ?", targetID).Pluck("user_id", &authorIDs)
+	} else {
+		db.Table("user_posts").Where("post_id = ?", targetID).Pluck("user_id", &authorIDs)
+	}
+	if len(authorIDs) == 0 {
+		return 0
+	}
+	return authorIDs[0]
+}
+
+// Flags a thread or post, users can flag each one once and can't flag their own
+func FlagContent(db *gorm.DB, user *User, targetType string, targetID uint, reason string) *errors.UserError {
+
+	if reasonErr := helpers.ValidateShortText("reason", reason); reasonErr != nil {
+		return reasonErr
+	}
+
+	if err := findTarget(db, user, targetType, targetID); err != nil {
+		return err
+	}
+
+	authorID := findTargetAuthor(db, targetType, targetID)
+	if authorID == user.ID {
+		return errors.ErrBadRecord
+	}
+
+	var existing Flag
+	db.Where("user_id = ? AND target_type = ? AND target_id = ?", user.ID, targetType, targetID).First(&existing)
+	if existing.ID > 0 {
+		return errors.ErrExists
+	}
+
+	flag := Flag{UserID: user.ID, AuthorID: authorID, TargetType: targetType, TargetID: targetID, Reason: reason, Timestamp: MakeTimestamp()}
+	if err := db.Create(&flag).Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+
+}
+
+// Gets the flags older than timestamp, newest first
+func GetFlags(db *gorm.DB, timestamp int64, limit int, flags *[]Flag) {
+	db.Order("timestamp desc").Limit(limit).Where("timestamp < ?", timestamp).Find(flags)
+}
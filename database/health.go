@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+// Every model Setup migrates
+var models = []interface{}{&User{}, &Thread{}, &Post{}, &BlockRecord{}, &Mention{}, &Notification{}, &Quote{}, &Reaction{}, &Poll{}, &PollOption{},
+	&PollVote{}, &Attachment{}, &Conversation{}, &ConversationParticipant{}, &Message{}, &Draft{}, &ReadPosition{}, &Bookmark{}, &Flag{}, &Ban{}, &AuditEntry{}}
+
+// The many to many tables gorm creates for the associations rather than from a model
+var joinTables = []string{"thread_posts", "user_threads", "user_posts"}
+
+// Checks the database can be reached, giving up when ctx is done
+func Ping(ctx context.Context, db *gorm.DB) error {
+	return db.DB().PingContext(ctx)
+}
+
+// Checks every table Setup creates is there, so a database that hasn't been migrated isn't served from
+func CheckSchema(db *gorm.DB) error {
+	var missing []string
+	for _, model := range models {
+		if !db.HasTable(model) {
+			missing = append(missing, db.NewScope(model).TableName())
+		}
+	}
+	for _, table := range joinTables {
+		if !db.HasTable(table) {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/helpers"
+	"ForumDatabase/errors"
+)
+
+const (
+	NotificationMention = "mention"
+)
+
+type Mention struct {
+	BaseModel
+	UserID uint `json:"userId"`
+	AuthorID uint `json:"authorId"`
+	ThreadID uint `json:"threadId"`
+	PostID uint `json:"postId"`
+}
+
+type Notification struct {
+	BaseModel
+	UserID uint `json:"-"`
+	Actor User `json:"actor"`
+	ActorID uint `json:"-"`
+	Kind string `json:"kind"`
+	ThreadID uint `json:"threadId"`
+	PostID uint `json:"postId"`
+	Read bool `json:"read"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Resolves the @mentions in content, stores a mention record for each and notifies the mentioned users
+// postID is 0 when the mentions are in the thread's own content
+func RecordMentions(db *gorm.DB, author *User, threadID uint, postID uint, content string) error {
+
+	usernames := helpers.ParseMentions(content)
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	var users []User
+	db.Where("username IN (?) AND id <> ?", usernames, author.ID).Find(&users)
+
+	for _, mentioned := range users {
+		mention := Mention{UserID: mentioned.ID, AuthorID: author.ID, ThreadID: threadID, PostID: postID}
+		if err := db.Create(&mention).Error; err != nil {
+			return err
+		}
+
+		if HasBlocked(db, mentioned.ID, author.ID) {
+			continue
+		}
+
+		notification := Notification{UserID: mentioned.ID, ActorID: author.ID, Kind: NotificationMention,
+			ThreadID: threadID, PostID: postID, Timestamp: MakeTimestamp()}
+		if err := db.Create(&notification).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// Gets the usernames that were mentioned in a thread (postID 0) or post
+func GetMentionedUsernames(db *gorm.DB, threadID uint, postID uint) []string {
+	var usernames []string
+	query := db.Table("mentions").Joins("INNER JOIN users ON users.id = mentions.user_id")
+	if postID > 0 {
+		query = query.Where("mentions.post_id = ?", postID)
+	} else {
+		query = query.Where("mentions.thread_id = ? AND mentions.post_id = ?", threadID, 0)
+	}
+	query.Pluck("users.username", &usernames)
+	return usernames
+}
+
+// Gets the usernames mentioned in each of the threads' own content or each of the posts, keyed by ID
+func loadMentionedUsernames(db *gorm.DB, target string, ids []uint) map[uint][]string {
+	usernames := make(map[uint][]string)
+	if len(ids) == 0 {
+		return usernames
+	}
+
+	query := db.Table("mentions").Joins("INNER JOIN users ON users.id = mentions.user_id")
+	if target == TargetPost {
+		query = query.Select("mentions.post_id, users.username").Where("mentions.post_id IN (?)", ids)
+	} else {
+		query = query.Select("mentions.thread_id, users.username").Where("mentions.thread_id IN (?) AND mentions.post_id = ?", ids, 0)
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return usernames
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint
+		var username string
+		rows.Scan(&id, &username)
+		usernames[id] = append(usernames[id], username)
+	}
+	return usernames
+}
+
+// Checks whether the user has a block record for the target
+func HasBlocked(db *gorm.DB, userID uint, targetID uint) bool {
+	var count int64
+	db.Model(&BlockRecord{}).Where("user_id = ? AND target_id = ?", userID, targetID).Count(&count)
+	return count > 0
+}
+
+// Gets the notifications for the user older than timestamp, newest first
+func GetNotifications(db *gorm.DB, user *User, timestamp int64, limit int, notifications *[]Notification) {
+	db.Preload("Actor").Order("timestamp desc").Limit(limit).Where("user_id = ? AND timestamp < ?", user.ID, timestamp).Find(&notifications)
+}
+
+// Counts the notifications the user hasn't read yet
+func CountUnreadNotifications(db *gorm.DB, user *User) int64 {
+	var count int64
+	db.Model(&Notification{}).Where("user_id = ? AND `read` = ?", user.ID, false).Count(&count)
+	return count
+}
+
+// Marks one of the user's notifications as read
+func MarkNotificationRead(db *gorm.DB, user *User, id uint) *errors.UserError {
+	var notification Notification
+	db.Where("user_id = ?", user.ID).First(&notification, id)
+	if notification.ID < 1 {
+		return errors.ErrNotExist
+	}
+	notification.Read = true
+	db.Save(&notification)
+	return nil
+}
@@ -0,0 +1,323 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/errors"
+	"ForumDatabase/spam"
+	"time"
+)
+
+const (
+	RoleUser = iota
+	RoleModerator
+	RoleAdmin
+)
+
+var (
+	SpamPipeline = spam.DefaultPipeline(nil)
+	SpamHistoryWindow = time.Hour * 24
+	SpamVelocityWindow = time.Minute * 10
+	MaxSpamHistory = 20
+)
+
+// Checks whether the user can see held content and use the moderation endpoints
+func (user *User) IsModerator() bool {
+	return user.Role >= RoleModerator
+}
+
+func (user *User) IsAdmin() bool {
+	return user.Role >= RoleAdmin
+}
+
+// Limits a thread query to what the viewer (who may be nil) can see,
+// held and shadowed threads only being visible to their authors and moderators
+func visibleThreads(db *gorm.DB, viewer *User) *gorm.DB {
+	if viewer == nil {
+		return db.Where("threads.held = ? AND threads.shadowed = ?", false, false)
+	}
+	if viewer.IsModerator() {
+		return db
+	}
+	return db.Where("(threads.held = ? AND threads.shadowed = ?) OR threads.id IN (SELECT thread_id FROM user_threads WHERE user_id = ?)", false, false, viewer.ID)
+}
+
+// Limits a post query to what the viewer (who may be nil) can see,
+// held and shadowed posts only being visible to their authors and moderators
+func visiblePosts(db *gorm.DB, viewer *User) *gorm.DB {
+	if viewer == nil {
+		return db.Where("posts.held = ? AND posts.shadowed = ?", false, false)
+	}
+	if viewer.IsModerator() {
+		return db
+	}
+	return db.Where("(posts.held = ? AND posts.shadowed = ?) OR posts.id IN (SELECT post_id FROM user_posts WHERE user_id = ?)", false, false, viewer.ID)
+}
+
+// Checks whether the viewer (who may be nil) can see the thread
+func canViewThread(db *gorm.DB, viewer *User, thread *Thread) bool {
+	if !thread.Held && !thread.Shadowed {
+		return true
+	}
+	if viewer == nil {
+		return false
+	}
+	var count int64
+	visibleThreads(db.Model(&Thread{}), viewer).Where("threads.id = ?", thread.ID).Count(&count)
+	return count > 0
+}
+
+// Finds a thread by id if it hasn't been deleted and the viewer (who may be nil) can see it
+func FindVisibleThread(db *gorm.DB, viewer *User, id uint) (*Thread, *errors.UserError) {
+	var thread Thread
+	visibleThreads(db, viewer).Where("threads.deleted = ?", false).First(&thread, id)
+	if thread.ID > 0 {
+		return &thread, nil
+	} else {
+		return nil, errors.ErrNotExist
+	}
+}
+
+// Finds a post by id if it hasn't been deleted and the viewer (who may be nil) can see both it and its thread
+func FindVisiblePost(db *gorm.DB, viewer *User, id uint) (*Post, *errors.UserError) {
+	var post Post
+	visiblePosts(db, viewer).Where("posts.deleted = ?", false).First(&post, id)
+	if post.ID < 1 {
+		return nil, errors.ErrNotExist
+	}
+	if _, err := FindVisibleThread(db, viewer, findPostThreadId(db, post.ID)); err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// Runs content from the author through the spam pipeline, returning whether it should be held for moderation
+func assessSpam(db *gorm.DB, author *User, content string) (bool, *errors.UserError) {
+
+	if SpamPipeline == nil || author.IsModerator() {
+		return false, nil
+	}
+
+	now := MakeTimestamp()
+	historySince := now - int64(SpamHistoryWindow / time.Millisecond)
+	velocitySince := now - int64(SpamVelocityWindow / time.Millisecond)
+
+	var recentPosts, recentThreads []string
+	db.Table("posts").Joins("INNER JOIN user_posts ON user_posts.post_id = posts.id").
+			Where("user_posts.user_id = ? AND posts.timestamp > ?", author.ID, historySince).
+			Order("posts.timestamp desc").Limit(MaxSpamHistory).Pluck("posts.content", &recentPosts)
+	db.Table("threads").Joins("INNER JOIN user_threads ON user_threads.thread_id = threads.id").
+			Where("user_threads.user_id = ? AND threads.timestamp > ?", author.ID, historySince).
+			Order("threads.timestamp desc").Limit(MaxSpamHistory).Pluck("threads.content", &recentThreads)
+
+	var postCount, threadCount int64
+	db.Table("posts").Joins("INNER JOIN user_posts ON user_posts.post_id = posts.id").
+			Where("user_posts.user_id = ? AND posts.timestamp > ?", author.ID, velocitySince).Count(&postCount)
+	db.Table("threads").Joins("INNER JOIN user_threads ON user_threads.thread_id = threads.id").
+			Where("user_threads.user_id = ? AND threads.timestamp > ?", author.ID, velocitySince).Count(&threadCount)
+
+	result := SpamPipeline.Run(&spam.Content{
+		Text: content,
+		AccountAge: time.Since(author.CreatedAt),
+		RecentTexts: append(recentPosts, recentThreads...),
+		RecentCount: int(postCount + threadCount),
+	})
+
+	switch result.Verdict {
+	case spam.Reject:
+		return false, errors.ErrSpam
+	case spam.Hold:
+		return true, nil
+	}
+	return false, nil
+
+}
+
+// Gives the users with the given usernames the admin role
+func PromoteAdmins(db *gorm.DB, usernames []string) {
+	if len(usernames) > 0 {
+		db.Model(&User{}).Where("username IN (?)", usernames).UpdateColumn("role", RoleAdmin)
+	}
+}
+
+// Changes the role of the target user
+func SetUserRole(db *gorm.DB, admin *User, targetId uint, role int) *errors.UserError {
+	if role < RoleUser || role > RoleAdmin {
+		return errors.ErrBadRecord
+	}
+	target, err := FindUser(db, targetId)
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	if err := tx.Model(target).UpdateColumn("role", role).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := recordAudit(tx, admin, AuditSetRole, TargetUser, target.ID, map[string]int{"role": target.Role}, map[string]int{"role": role}); err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+}
+
+// Gets the held threads and posts older than timestamp waiting for moderation, newest first
+func GetHeldContent(db *gorm.DB, timestamp int64, limit int, threads *[]Thread, posts *[]Post) {
+	db.Preload("Authors").Order("timestamp desc").Limit(limit).
+			Where("held = ? AND deleted = ? AND timestamp < ?", true, false, timestamp).Find(threads)
+	db.Preload("Authors").Preload("Threads").Order("timestamp desc").Limit(limit).
+			Where("held = ? AND deleted = ? AND timestamp < ?", true, false, timestamp).Find(posts)
+}
+
+// Publishes a held thread, sending the mention notifications that were held back with it
+func ApproveThread(db *gorm.DB, moderator *User, threadId uint) *errors.UserError {
+
+	var thread Thread
+	db.Preload("Authors").Where("held = ? AND deleted = ?", true, false).First(&thread, threadId)
+	if thread.ID < 1 {
+		return errors.ErrNotExist
+	}
+
+	tx := db.Begin()
+	if err := tx.Model(&thread).UpdateColumns(map[string]interface{}{"held": false, "revision": thread.Revision + 1}).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if !thread.Shadowed {
+		for i := range thread.Authors {
+			if err := RecordMentions(tx, &thread.Authors[i], thread.ID, 0, thread.Content); err != nil {
+				tx.Rollback()
+				return errors.ErrSystem
+			}
+			if err := countThread(tx, thread.Authors[i].ID, 1); err != nil {
+				tx.Rollback()
+				return errors.ErrSystem
+			}
+		}
+	}
+	if err := recordAudit(tx, moderator, AuditApproveThread, TargetThread, thread.ID, map[string]bool{"held": true}, map[string]bool{"held": false}); err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+
+}
+
+// Publishes a held post, counting it towards its thread's activity now that others can see it
+func ApprovePost(db *gorm.DB, moderator *User, postId uint) *errors.UserError {
+
+	var post Post
+	db.Preload("Authors").Preload("Threads").Where("held = ? AND deleted = ?", true, false).First(&post, postId)
+	if post.ID < 1 || len(post.Threads) == 0 {
+		return errors.ErrNotExist
+	}
+	thread := post.Threads[0]
+
+	tx := db.Begin()
+	if err := tx.Model(&post).UpdateColumns(map[string]interface{}{"held": false, "revision": post.Revision + 1}).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if !post.Shadowed {
+		for i := range post.Authors {
+			if err := RecordMentions(tx, &post.Authors[i], thread.ID, post.ID, post.Content); err != nil {
+				tx.Rollback()
+				return errors.ErrSystem
+			}
+		}
+		// Posts are only ever written by one author, who the thread's activity is counted towards once
+		if len(post.Authors) > 0 {
+			if err := bumpThread(tx, thread.ID, post.Authors[0].ID, post.Timestamp); err != nil {
+				tx.Rollback()
+				return errors.ErrSystem
+			}
+		}
+	}
+	if err := recordAudit(tx, moderator, AuditApprovePost, TargetPost, post.ID, map[string]bool{"held": true}, map[string]bool{"held": false}); err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+
+}
+
+// Deletes a held thread
+func RejectThread(db *gorm.DB, moderator *User, threadId uint) *errors.UserError {
+	var thread Thread
+	db.Where("held = ? AND deleted = ?", true, false).First(&thread, threadId)
+	if thread.ID < 1 {
+		return errors.ErrNotExist
+	}
+
+	tx := db.Begin()
+	if err := tx.Model(&thread).UpdateColumns(map[string]interface{}{"deleted": true, "deleted_timestamp": MakeTimestamp()}).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := recordAudit(tx, moderator, AuditRejectThread, TargetThread, thread.ID, thread, map[string]bool{"deleted": true}); err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+}
+
+// Deletes a held post
+func RejectPost(db *gorm.DB, moderator *User, postId uint) *errors.UserError {
+	var post Post
+	db.Where("held = ? AND deleted = ?", true, false).First(&post, postId)
+	if post.ID < 1 {
+		return errors.ErrNotExist
+	}
+
+	tx := db.Begin()
+	if err := tx.Model(&post).UpdateColumns(map[string]interface{}{"deleted": true, "deleted_timestamp": MakeTimestamp()}).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := recordAudit(tx, moderator, AuditRejectPost, TargetPost, post.ID, post, map[string]bool{"deleted": true}); err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+}
+
+// Shadow bans or unshadow bans a user, only affecting what they post from now on
+func SetShadowBanned(db *gorm.DB, moderator *User, targetId uint, shadowBanned bool) *errors.UserError {
+	target, err := FindUser(db, targetId)
+	if err != nil {
+		return err
+	}
+	if target.ID == moderator.ID || (target.IsModerator() && !moderator.IsAdmin()) {
+		return errors.ErrForbidden
+	}
+
+	tx := db.Begin()
+	if err := tx.Model(target).UpdateColumn("shadow_banned", shadowBanned).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := recordAudit(tx, moderator, AuditShadowBan, TargetUser, target.ID,
+		map[string]bool{"shadowBanned": target.ShadowBanned}, map[string]bool{"shadowBanned": shadowBanned}); err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+}
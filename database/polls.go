@@ -0,0 +1,222 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/helpers"
+	"ForumDatabase/errors"
+	"strings"
+)
+
+var (
+	HidePollResultsUntilVoted = true
+)
+
+type NewPoll struct {
+	Question string `json:"question"`
+	Options []string `json:"options"`
+	Multiple bool `json:"multiple"`
+	ClosesAt int64 `json:"closesAt"`
+}
+
+type Poll struct {
+	BaseModel
+	ThreadID uint `json:"-"`
+	Question string `json:"question"`
+	Multiple bool `json:"multiple"`
+	ClosesAt int64 `json:"closesAt"`
+	Options []PollOption `json:"options"`
+	Closed bool `json:"closed" gorm:"-"`
+	Voted bool `json:"voted" gorm:"-"`
+	MyVotes []uint `json:"myVotes" gorm:"-"`
+	ResultsVisible bool `json:"resultsVisible" gorm:"-"`
+}
+
+type PollOption struct {
+	BaseModel
+	PollID uint `json:"-"`
+	Text string `json:"text"`
+	Position int `json:"position"`
+	Votes *int64 `json:"votes,omitempty" gorm:"-"`
+}
+
+type PollVote struct {
+	BaseModel
+	PollID uint
+	OptionID uint
+	UserID uint
+}
+
+// Checks the poll has a question, 2-20 unique options and a close time that isn't in the past
+func ValidatePoll(poll *NewPoll) *errors.UserError {
+
+	validator := new (helpers.Validator)
+	validator.Length("poll.question", poll.Question, 1, helpers.MaxLengthShortText)
+	validator.Count("poll.options", len(poll.Options), helpers.MinPollOptions, helpers.MaxPollOptions)
+
+	var seen []string
+	for _, option := range poll.Options {
+		trimmed := strings.TrimSpace(option)
+		if helpers.StringInSlice(seen, trimmed) {
+			validator.Unique("poll.options", false)
+			break
+		}
+		validator.Length("poll.options", trimmed, 1, helpers.MaxLengthShortText)
+		seen = append(seen, trimmed)
+	}
+
+	if poll.ClosesAt != 0 {
+		validator.Min("poll.closesAt", poll.ClosesAt, MakeTimestamp() + 1)
+	}
+
+	return validator.Error()
+
+}
+
+// Stores the poll and its options for the thread
+func createPoll(db *gorm.DB, threadId uint, newPoll *NewPoll) (*Poll, error) {
+	poll := Poll{ThreadID: threadId, Question: strings.TrimSpace(newPoll.Question), Multiple: newPoll.Multiple, ClosesAt: newPoll.ClosesAt}
+	for i, text := range newPoll.Options {
+		poll.Options = append(poll.Options, PollOption{Text: strings.TrimSpace(text), Position: i})
+	}
+	if err := db.Create(&poll).Error; err != nil {
+		return nil, err
+	}
+	return &poll, nil
+}
+
+// Finds the poll attached to a thread
+func FindThreadPoll(db *gorm.DB, threadId uint) (*Poll, *errors.UserError) {
+	var poll Poll
+	db.Preload("Options", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Where("thread_id = ?", threadId).First(&poll)
+	if poll.ID > 0 {
+		return &poll, nil
+	} else {
+		return nil, errors.ErrNotExist
+	}
+}
+
+// Replaces the user's votes in the thread's poll with optionIds, as long as the poll is still open
+func VoteInPoll(db *gorm.DB, user *User, threadId uint, optionIds []uint) *errors.UserError {
+
+	if _, threadErr := FindVisibleThread(db, user, threadId); threadErr != nil {
+		return threadErr
+	}
+
+	poll, pollErr := FindThreadPoll(db, threadId)
+	if pollErr != nil {
+		return pollErr
+	}
+
+	if poll.isClosed() {
+		return errors.ErrBadRecord
+	}
+
+	if len(optionIds) == 0 || (!poll.Multiple && len(optionIds) > 1) {
+		return errors.ErrBadRecord
+	}
+
+	var validIds []uint
+	for _, option := range poll.Options {
+		validIds = append(validIds, option.ID)
+	}
+
+	var chosen []uint
+	for _, id := range optionIds {
+		if !helpers.UintInSlice(validIds, id) {
+			return errors.ErrBadRecord
+		}
+		if !helpers.UintInSlice(chosen, id) {
+			chosen = append(chosen, id)
+		}
+	}
+
+	// Locking the voter's row serializes their concurrent votes, even before they have any votes to lock
+	tx := db.Begin()
+	var voter User
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Select("id").First(&voter, user.ID).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	var existing []PollVote
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("poll_id = ? AND user_id = ?", poll.ID, user.ID).Find(&existing).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Where("poll_id = ? AND user_id = ?", poll.ID, user.ID).Delete(&PollVote{}).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	for _, id := range chosen {
+		if err := tx.Create(&PollVote{PollID: poll.ID, OptionID: id, UserID: user.ID}).Error; err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+
+}
+
+func (poll *Poll) isClosed() bool {
+	return poll.ClosesAt != 0 && poll.ClosesAt <= MakeTimestamp()
+}
+
+// Fills in the viewer's votes, and the tallies if the viewer is allowed to see them yet
+func (poll *Poll) loadResults(db *gorm.DB, viewer *User) {
+
+	poll.Closed = poll.isClosed()
+
+	if viewer != nil {
+		db.Model(&PollVote{}).Where("poll_id = ? AND user_id = ?", poll.ID, viewer.ID).Pluck("option_id", &poll.MyVotes)
+	}
+	poll.Voted = len(poll.MyVotes) > 0
+	poll.ResultsVisible = !HidePollResultsUntilVoted || poll.Voted || poll.Closed
+
+	if !poll.ResultsVisible {
+		return
+	}
+
+	tallies := make(map[uint]int64)
+	rows, err := db.Table("poll_votes").Select("option_id, COUNT(*)").Where("poll_id = ?", poll.ID).Group("option_id").Rows()
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var optionID uint
+			var count int64
+			rows.Scan(&optionID, &count)
+			tallies[optionID] = count
+		}
+	}
+
+	for i := range poll.Options {
+		count := tallies[poll.Options[i].ID]
+		poll.Options[i].Votes = &count
+	}
+
+}
+
+// Gets a thread with its authors, poll and reactions as seen by the viewer, who may be nil
+func GetThreadDetail(db *gorm.DB, viewer *User, threadId uint) (*Thread, *errors.UserError) {
+
+	var thread Thread
+	db.Preload("Authors").Where("deleted = ?", false).First(&thread, threadId)
+	if thread.ID < 1 || !canViewThread(db, viewer, &thread) {
+		return nil, errors.ErrNotExist
+	}
+
+	if poll, err := FindThreadPoll(db, thread.ID); err == nil {
+		poll.loadResults(db, viewer)
+		thread.Poll = poll
+	}
+
+	db.Where("thread_id = ?", thread.ID).Order("timestamp").Find(&thread.Attachments)
+
+	threads := []Thread{thread}
+	LoadThreadReactions(db, viewer, threads)
+	return &threads[0], nil
+
+}
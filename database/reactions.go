@@ -0,0 +1,193 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/helpers"
+	"ForumDatabase/errors"
+)
+
+const (
+	TargetThread = "thread"
+	TargetPost = "post"
+)
+
+type Reaction struct {
+	BaseModel
+	User User `json:"user"`
+	UserID uint `json:"-"`
+	TargetType string `json:"targetType"`
+	TargetID uint `json:"targetId"`
+	Emoji string `json:"emoji"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int64 `json:"count"`
+}
+
+// Checks the thread or post being targeted exists, hasn't been deleted and the viewer can see it
+func findTarget(db *gorm.DB, viewer *User, targetType string, targetID uint) *errors.UserError {
+	switch targetType {
+	case TargetThread:
+		_, err := FindVisibleThread(db, viewer, targetID)
+		return err
+	case TargetPost:
+		_, err := FindVisiblePost(db, viewer, targetID)
+		return err
+	default:
+		return errors.ErrBadRecord
+	}
+}
+
+// Adds a reaction from the user to a thread or post
+func AddReaction(db *gorm.DB, user *User, targetType string, targetID uint, emoji string) *errors.UserError {
+
+	if !helpers.StringInSlice(helpers.AllowedReactions, emoji) {
+		return errors.ErrBadRecord
+	}
+
+	if err := findTarget(db, user, targetType, targetID); err != nil {
+		return err
+	}
+
+	var existing Reaction
+	db.Where("user_id = ? AND target_type = ? AND target_id = ? AND emoji = ?", user.ID, targetType, targetID, emoji).First(&existing)
+	if existing.ID > 0 {
+		return errors.ErrExists
+	}
+
+	reaction := Reaction{UserID: user.ID, TargetType: targetType, TargetID: targetID, Emoji: emoji, Timestamp: MakeTimestamp()}
+	tx := db.Begin()
+	if err := tx.Create(&reaction).Error; err != nil {
+		tx.Rollback()
+		if isDuplicateKey(err) {
+			return errors.ErrExists
+		}
+		return errors.ErrSystem
+	}
+	if targetType == TargetThread {
+		if err := tx.Model(&Thread{}).Where("id = ?", targetID).UpdateColumn("reactions_count", gorm.Expr("reactions_count + ?", 1)).Error; err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+		refreshHotScore(tx, targetID)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+
+}
+
+// Removes one of the user's reactions from a thread or post
+func RemoveReaction(db *gorm.DB, user *User, targetType string, targetID uint, emoji string) *errors.UserError {
+	var reaction Reaction
+	db.Where("user_id = ? AND target_type = ? AND target_id = ? AND emoji = ?", user.ID, targetType, targetID, emoji).First(&reaction)
+	if reaction.ID > 0 {
+		tx := db.Begin()
+		if err := tx.Unscoped().Delete(&reaction).Error; err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+		if targetType == TargetThread {
+			if err := tx.Model(&Thread{}).Where("id = ? AND reactions_count > ?", targetID, 0).UpdateColumn("reactions_count", gorm.Expr("reactions_count - ?", 1)).Error; err != nil {
+				tx.Rollback()
+				return errors.ErrSystem
+			}
+			refreshHotScore(tx, targetID)
+		}
+		if err := tx.Commit().Error; err != nil {
+			return errors.ErrSystem
+		}
+		return nil
+	} else {
+		return errors.ErrNotExist
+	}
+}
+
+// Gets who reacted to a thread or post the viewer can see, optionally only with one emoji
+func GetReactions(db *gorm.DB, viewer *User, targetType string, targetID uint, emoji string, timestamp int64, limit int, reactions *[]Reaction) {
+	if findTarget(db, viewer, targetType, targetID) != nil {
+		return
+	}
+	query := db.Preload("User").Order("timestamp desc").Limit(limit).Where("target_type = ? AND target_id = ? AND timestamp < ?", targetType, targetID, timestamp)
+	if emoji != "" {
+		query = query.Where("emoji = ?", emoji)
+	}
+	query.Find(&reactions)
+}
+
+// Counts reactions per emoji for each target id, and collects the viewer's own reactions if there is one
+func summarizeReactions(db *gorm.DB, viewer *User, targetType string, ids []uint) (map[uint][]ReactionCount, map[uint][]string) {
+
+	counts := make(map[uint][]ReactionCount)
+	mine := make(map[uint][]string)
+
+	if len(ids) == 0 {
+		return counts, mine
+	}
+
+	rows, err := db.Table("reactions").Select("target_id, emoji, COUNT(*)").Where("target_type = ? AND target_id IN (?)", targetType, ids).
+			Group("target_id, emoji").Rows()
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var targetID uint
+			var count ReactionCount
+			rows.Scan(&targetID, &count.Emoji, &count.Count)
+			counts[targetID] = append(counts[targetID], count)
+		}
+	}
+
+	if viewer != nil {
+		var own []Reaction
+		db.Where("user_id = ? AND target_type = ? AND target_id IN (?)", viewer.ID, targetType, ids).Find(&own)
+		for _, reaction := range own {
+			mine[reaction.TargetID] = append(mine[reaction.TargetID], reaction.Emoji)
+		}
+	}
+
+	return counts, mine
+
+}
+
+// Fills in the reaction counts, and the viewer's own reactions when viewer isn't nil
+func LoadThreadReactions(db *gorm.DB, viewer *User, threads []Thread) {
+	var ids []uint
+	for _, thread := range threads {
+		ids = append(ids, thread.ID)
+	}
+	counts, mine := summarizeReactions(db, viewer, TargetThread, ids)
+	for i := range threads {
+		threads[i].Reactions = counts[threads[i].ID]
+		threads[i].MyReactions = mine[threads[i].ID]
+	}
+}
+
+// Fills in the reaction counts for posts and any nested replies, and the viewer's own reactions when viewer isn't nil
+func LoadPostReactions(db *gorm.DB, viewer *User, posts []Post) {
+
+	var ids []uint
+	var collect func(posts []Post)
+	collect = func(posts []Post) {
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+			collect(post.Replies)
+		}
+	}
+	collect(posts)
+
+	counts, mine := summarizeReactions(db, viewer, TargetPost, ids)
+
+	var fill func(posts []Post)
+	fill = func(posts []Post) {
+		for i := range posts {
+			posts[i].Reactions = counts[posts[i].ID]
+			posts[i].MyReactions = mine[posts[i].ID]
+			fill(posts[i].Replies)
+		}
+	}
+	fill(posts)
+
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/errors"
+)
+
+var (
+	MaxReadPositions = 1000
+)
+
+// How far the user has read in a thread, anything posted at or before LastReadTimestamp has been seen
+type ReadPosition struct {
+	BaseModel
+	UserID uint
+	ThreadID uint
+	LastReadTimestamp int64
+}
+
+// Moves the user's read position in a thread forward to timestamp
+func MarkThreadRead(db *gorm.DB, user *User, threadId uint, timestamp int64) {
+
+	if timestamp <= user.ReadWatermark {
+		return
+	}
+
+	var position ReadPosition
+	db.Where("user_id = ? AND thread_id = ?", user.ID, threadId).First(&position)
+	if position.ID > 0 && position.LastReadTimestamp >= timestamp {
+		return
+	}
+
+	// Count the newly read posts towards the user's trust level
+	readFrom := user.ReadWatermark
+	if position.LastReadTimestamp > readFrom {
+		readFrom = position.LastReadTimestamp
+	}
+	var newlyRead int64
+	db.Table("posts").Joins("INNER JOIN thread_posts ON thread_posts.post_id = posts.id").
+			Where("thread_posts.thread_id = ? AND posts.deleted = ? AND posts.timestamp > ? AND posts.timestamp <= ?", threadId, false, readFrom, timestamp).
+			Count(&newlyRead)
+
+	tx := db.Begin()
+	if newlyRead > 0 {
+		if err := tx.Model(user).UpdateColumn("posts_read", gorm.Expr("posts_read + ?", newlyRead)).Error; err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+
+	position.UserID = user.ID
+	position.ThreadID = threadId
+	position.LastReadTimestamp = timestamp
+	if err := tx.Save(&position).Error; err != nil {
+		tx.Rollback()
+		return
+	}
+
+	if err := compactReadPositions(tx, user); err != nil {
+		tx.Rollback()
+		return
+	}
+	if tx.Commit().Error == nil {
+		user.PostsRead = user.PostsRead + newlyRead
+	}
+
+}
+
+// Marks the thread read up to the newest of the fetched posts (including nested replies)
+func MarkPostsRead(db *gorm.DB, user *User, threadId uint, posts []Post) {
+	var newest int64
+	var walk func(posts []Post)
+	walk = func(posts []Post) {
+		for _, post := range posts {
+			if post.Timestamp > newest {
+				newest = post.Timestamp
+			}
+			walk(post.Replies)
+		}
+	}
+	walk(posts)
+
+	if newest > 0 {
+		MarkThreadRead(db, user, threadId, newest)
+	}
+}
+
+// Marks everything in a thread as read
+func MarkWholeThreadRead(db *gorm.DB, user *User, threadId uint) *errors.UserError {
+	thread, err := FindVisibleThread(db, user, threadId)
+	if err != nil {
+		return err
+	}
+	MarkThreadRead(db, user, threadId, thread.LastUpdate)
+	return nil
+}
+
+// Marks everything as read by moving the watermark to now and dropping the per thread positions
+func MarkAllRead(db *gorm.DB, user *User) {
+	watermark := MakeTimestamp()
+	tx := db.Begin()
+	if err := tx.Model(user).Update("read_watermark", watermark).Error; err != nil {
+		tx.Rollback()
+		return
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&ReadPosition{}).Error; err != nil {
+		tx.Rollback()
+		return
+	}
+	if tx.Commit().Error == nil {
+		user.ReadWatermark = watermark
+	}
+}
+
+// Keeps storage bounded: once a user has too many positions the oldest ones collapse into the watermark
+func compactReadPositions(db *gorm.DB, user *User) error {
+
+	var count int
+	db.Model(&ReadPosition{}).Where("user_id = ?", user.ID).Count(&count)
+	if count <= MaxReadPositions {
+		return nil
+	}
+
+	// Collapse down to 90% so this doesn't run on every new position
+	excess := count - MaxReadPositions * 9 / 10
+	var timestamps []int64
+	db.Model(&ReadPosition{}).Where("user_id = ?", user.ID).Order("last_read_timestamp").Limit(excess).Pluck("last_read_timestamp", &timestamps)
+	if len(timestamps) == 0 {
+		return nil
+	}
+
+	watermark := timestamps[len(timestamps) - 1]
+	if watermark > user.ReadWatermark {
+		if err := db.Model(user).Update("read_watermark", watermark).Error; err != nil {
+			return err
+		}
+		user.ReadWatermark = watermark
+	}
+	return db.Where("user_id = ? AND last_read_timestamp <= ?", user.ID, user.ReadWatermark).Delete(&ReadPosition{}).Error
+
+}
+
+// Fills in how many posts the user hasn't read yet in each thread, and the first of them
+func LoadUnreadCounts(db *gorm.DB, user *User, threads []Thread) {
+
+	if len(threads) == 0 {
+		return
+	}
+
+	var ids []uint
+	for _, thread := range threads {
+		ids = append(ids, thread.ID)
+	}
+
+	type unread struct {
+		count int64
+		firstPostId uint
+	}
+	unreadByThread := make(map[uint]unread)
+
+	rows, err := db.Table("posts").Select("thread_posts.thread_id, COUNT(*), MIN(posts.id)").
+			Joins("INNER JOIN thread_posts ON thread_posts.post_id = posts.id").
+			Joins("LEFT JOIN read_positions ON read_positions.thread_id = thread_posts.thread_id AND read_positions.user_id = ?", user.ID).
+			Where("thread_posts.thread_id IN (?) AND posts.deleted = ? AND posts.held = ? AND posts.shadowed = ? AND posts.timestamp > GREATEST(COALESCE(read_positions.last_read_timestamp, 0), ?)", ids, false, false, false, user.ReadWatermark).
+			Where("posts.id NOT IN (SELECT post_id FROM user_posts WHERE user_id = ?)", user.ID).
+			Group("thread_posts.thread_id").Rows()
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var threadId uint
+			var value unread
+			rows.Scan(&threadId, &value.count, &value.firstPostId)
+			unreadByThread[threadId] = value
+		}
+	}
+
+	for i := range threads {
+		value := unreadByThread[threads[i].ID]
+		count := value.count
+		threads[i].UnreadCount = &count
+		if value.firstPostId > 0 {
+			firstPostId := value.firstPostId
+			threads[i].FirstUnreadPostID = &firstPostId
+		}
+	}
+
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/helpers"
+	"ForumDatabase/errors"
+)
+
+var (
+	MaxReplyDepth = 5
+)
+
+type Quote struct {
+	BaseModel
+	ThreadID uint `json:"threadId"`
+	PostID uint `json:"postId"`
+	SourcePostID uint `json:"sourcePostId"`
+}
+
+// Finds a post by id if it belongs to the thread and hasn't been deleted
+func FindThreadPost(db *gorm.DB, threadId uint, postId uint) (*Post, *errors.UserError) {
+	var post Post
+	db.Joins("INNER JOIN thread_posts ON thread_posts.post_id = posts.id").Where("id = ? AND thread_id = ? AND deleted = ?", postId, threadId, false).First(&post)
+	if post.ID > 0 {
+		return &post, nil
+	} else {
+		return nil, errors.ErrNotExist
+	}
+}
+
+// Syncs the post's quote records with the [quote=id] blocks in content, so they follow the post through edits
+// Only visible posts in the same thread can be quoted
+func RecordQuotes(db *gorm.DB, threadId uint, postId uint, content string) error {
+
+	sourceIDs := helpers.ParseQuotes(content)
+
+	stale := db.Where("post_id = ?", postId)
+	if len(sourceIDs) > 0 {
+		stale = stale.Where("source_post_id NOT IN (?)", sourceIDs)
+	}
+	if err := stale.Delete(&Quote{}).Error; err != nil {
+		return err
+	}
+	if len(sourceIDs) == 0 {
+		return nil
+	}
+
+	var newIDs []uint
+	err := db.Model(&Post{}).Joins("INNER JOIN thread_posts ON thread_posts.post_id = posts.id").
+			Where("posts.id IN (?) AND posts.id <> ? AND thread_posts.thread_id = ? AND " + visiblePostCondition, sourceIDs, postId, threadId).
+			Where("posts.id NOT IN (SELECT source_post_id FROM quotes WHERE post_id = ?)", postId).Pluck("posts.id", &newIDs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, sourceID := range newIDs {
+		quote := Quote{ThreadID: threadId, PostID: postId, SourcePostID: sourceID}
+		if err := db.Create(&quote).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// Gets the ids of the posts quoted by a post
+func GetQuotedPostIds(db *gorm.DB, postId uint) []uint {
+	var ids []uint
+	db.Model(&Quote{}).Where("post_id = ?", postId).Pluck("source_post_id", &ids)
+	return ids
+}
+
+// Gets replies to parentId (0 for top level posts) that the viewer can see with their nested replies, up to depth levels
+// Replies to posts that were deleted or that the viewer can't see come back at the top level, like they do in flat mode
+func GetPostTree(db *gorm.DB, viewer *User, timestamp int64, limit int, threadId uint, parentId uint, depth int, posts *[]Post) {
+
+	if _, err := FindVisibleThread(db, viewer, threadId); err != nil {
+		return
+	}
+
+	if depth < 1 || depth > MaxReplyDepth {
+		depth = MaxReplyDepth
+	}
+
+	query := visiblePosts(db, viewer).Joins("INNER JOIN thread_posts ON thread_posts.post_id = posts.id").Order("timestamp").Preload("Authors").
+			Limit(limit).Where("timestamp < ? AND thread_id = ? AND deleted = ?", timestamp, threadId, false)
+	if parentId == 0 {
+		parents := visiblePosts(db.Model(&Post{}), viewer).Where("posts.deleted = ?", false).Select("posts.id").SubQuery()
+		query = query.Where("parent_post_id = 0 OR parent_post_id NOT IN ?", parents)
+	} else {
+		query = query.Where("parent_post_id = ?", parentId)
+	}
+	query.Find(posts)
+
+	attachReplies(db, viewer, threadId, *posts, depth - 1)
+
+}
+
+// Nests the replies of each post, flagging posts whose replies are past the depth limit
+func attachReplies(db *gorm.DB, viewer *User, threadId uint, posts []Post, depth int) {
+
+	if len(posts) == 0 {
+		return
+	}
+
+	var ids []uint
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+
+	query := visiblePosts(db, viewer).Joins("INNER JOIN thread_posts ON thread_posts.post_id = posts.id").
+			Where("thread_id = ? AND parent_post_id IN (?) AND deleted = ?", threadId, ids, false)
+
+	if depth < 1 {
+		var parentIDs []uint
+		query.Model(&Post{}).Pluck("DISTINCT parent_post_id", &parentIDs)
+		for i := range posts {
+			posts[i].HasMoreReplies = helpers.UintInSlice(parentIDs, posts[i].ID)
+		}
+		return
+	}
+
+	var replies []Post
+	query.Order("timestamp").Preload("Authors").Find(&replies)
+	attachReplies(db, viewer, threadId, replies, depth - 1)
+
+	for i := range posts {
+		for _, reply := range replies {
+			if reply.ParentPostID == posts[i].ID {
+				posts[i].Replies = append(posts[i].Replies, reply)
+			}
+		}
+	}
+
+}
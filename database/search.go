@@ -0,0 +1,37 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Finds threads whose title or content and posts whose content contain text, posted before timestamp, newest first
+// Only what the viewer (who may be nil) can see is found, so held and shadowed content and posts in hidden threads stay hidden
+func Search(db *gorm.DB, viewer *User, text string, timestamp int64, limit int, threads *[]Thread, posts *[]Post) {
+
+	pattern := "%" + likeEscaper.Replace(text) + "%"
+
+	threadScope := visibleThreads(db, viewer).Preload("Authors").Order("threads.timestamp desc").Limit(limit).
+			Where("threads.deleted = ? AND threads.timestamp < ? AND (threads.title LIKE ? OR threads.content LIKE ?)", false, timestamp, pattern, pattern)
+
+	visibleThreadIds := visibleThreads(db.Model(&Thread{}), viewer).Where("threads.deleted = ?", false).Select("threads.id").SubQuery()
+	postScope := visiblePosts(db, viewer).Preload("Authors").Preload("Threads").Order("posts.timestamp desc").Limit(limit).
+			Joins("INNER JOIN thread_posts ON thread_posts.post_id = posts.id").
+			Where("posts.deleted = ? AND posts.timestamp < ? AND posts.content LIKE ?", false, timestamp, pattern).
+			Where("thread_posts.thread_id IN ?", visibleThreadIds)
+
+	if viewer != nil {
+		var blockedIDs []int
+		GetBlockedIds(db, viewer, &blockedIDs)
+		if len(blockedIDs) > 0 {
+			threadScope = threadScope.Where("threads.id NOT IN (SELECT thread_id FROM user_threads WHERE user_id IN (?))", blockedIDs)
+			postScope = postScope.Where("posts.id NOT IN (SELECT post_id FROM user_posts WHERE user_id IN (?))", blockedIDs)
+		}
+	}
+
+	threadScope.Find(threads)
+	postScope.Find(posts)
+
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SortNewest = "newest"
+	SortActive = "active"
+	SortReplies = "replies"
+	SortTop = "top"
+	SortHot = "hot"
+)
+
+// The column each sort mode orders by, ties are broken by id
+var sortColumns = map[string]string{
+	SortNewest: "timestamp",
+	SortActive: "last_update",
+	SortReplies: "posts_count",
+	SortTop: "reactions_count",
+	SortHot: "hot_score",
+}
+
+// How far back "top" counts reactions for each window, 0 meaning all time
+var topWindows = map[string]time.Duration{
+	"day": time.Hour * 24,
+	"week": time.Hour * 24 * 7,
+	"month": time.Hour * 24 * 30,
+	"year": time.Hour * 24 * 365,
+	"all": 0,
+}
+
+type ThreadQuery struct {
+	Sort string
+	Window string
+	Cursor string
+	Timestamp int64
+	Limit int
+}
+
+// Scores activity on a log scale plus a bonus for being newer, so older threads sink without needing to be rescored
+func HotScore(thread *Thread) float64 {
+	activity := float64(thread.PostsCount + thread.ReactionsCount + 1)
+	return math.Log10(activity) + float64(thread.Timestamp / 1000) / 45000
+}
+
+// Recomputes the stored hot score of a thread after its activity changes
+func refreshHotScore(db *gorm.DB, threadId uint) {
+	var thread Thread
+	db.Select("id, timestamp, posts_count, reactions_count").First(&thread, threadId)
+	if thread.ID > 0 {
+		db.Model(&Thread{}).Where("id = ?", threadId).UpdateColumn("hot_score", HotScore(&thread))
+	}
+}
+
+// Gets the value a thread sorts by as it's written in a cursor
+func sortValue(thread *Thread, sort string) string {
+	switch sort {
+	case SortNewest:
+		return strconv.FormatInt(thread.Timestamp, 10)
+	case SortReplies:
+		return strconv.FormatInt(thread.PostsCount, 10)
+	case SortTop:
+		if thread.WindowReactionsCount != nil {
+			return strconv.FormatInt(*thread.WindowReactionsCount, 10)
+		}
+		return strconv.FormatInt(thread.ReactionsCount, 10)
+	case SortHot:
+		return strconv.FormatFloat(thread.HotScore, 'g', -1, 64)
+	default:
+		return strconv.FormatInt(thread.LastUpdate, 10)
+	}
+}
+
+// Counts the reactions a thread got since the timestamp, the "top" ordering for a window
+func windowReactionsExpression(since int64) string {
+	return fmt.Sprintf("(SELECT COUNT(*) FROM reactions WHERE reactions.target_type = '%s' AND reactions.target_id = threads.id AND reactions.timestamp >= %d)",
+		TargetThread, since)
+}
+
+// Fills in how many reactions each thread got since the timestamp with one grouped query
+func loadWindowReactionsCounts(db *gorm.DB, threads []Thread, since int64) {
+
+	if len(threads) == 0 {
+		return
+	}
+
+	var ids []uint
+	for _, thread := range threads {
+		ids = append(ids, thread.ID)
+	}
+
+	counts := make(map[uint]int64)
+	rows, err := db.Table("reactions").Select("target_id, COUNT(*)").
+			Where("target_type = ? AND target_id IN (?) AND timestamp >= ?", TargetThread, ids, since).Group("target_id").Rows()
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var id uint
+			var count int64
+			rows.Scan(&id, &count)
+			counts[id] = count
+		}
+	}
+
+	for i := range threads {
+		count := counts[threads[i].ID]
+		threads[i].WindowReactionsCount = &count
+	}
+
+}
+
+// Encodes the position after thread, along with where a "top" window started (0 for none) so later pages rank by the same window
+func encodeCursor(thread *Thread, sort string, windowSince int64) string {
+	cursor := fmt.Sprintf("%s:%d", sortValue(thread, sort), thread.ID)
+	if windowSince > 0 {
+		cursor += fmt.Sprintf(":%d", windowSince)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+func decodeCursor(cursor string) (string, uint, int64, bool) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, 0, false
+	}
+	parts := strings.SplitN(string(decoded), ":", 3)
+	if len(parts) < 2 {
+		return "", 0, 0, false
+	}
+	if _, err := strconv.ParseFloat(parts[0], 64); err != nil {
+		return "", 0, 0, false
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, 0, false
+	}
+	windowSince := int64(0)
+	if len(parts) == 3 {
+		if windowSince, err = strconv.ParseInt(parts[2], 10, 64); err != nil || windowSince <= 0 {
+			return "", 0, 0, false
+		}
+	}
+	return parts[0], uint(id), windowSince, true
+}
+
+// Gets a page of threads in the requested sort order, hiding held threads and threads by users the viewer (who may be nil) has blocked
+// Returns the cursor for the next page, empty when there are no more threads
+func GetThreads(db *gorm.DB, viewer *User, query ThreadQuery, threads *[]Thread) (string, *errors.UserError) {
+
+	if query.Sort == "" {
+		query.Sort = SortActive
+	}
+
+	column, exists := sortColumns[query.Sort]
+	if !exists {
+		return "", errors.ErrBadRecord
+	}
+
+	var cursorValue string
+	var cursorID uint
+	var cursorSince int64
+	if query.Cursor != "" {
+		var valid bool
+		if cursorValue, cursorID, cursorSince, valid = decodeCursor(query.Cursor); !valid {
+			return "", errors.ErrBadRecord
+		}
+	}
+
+	// "top" over a window ranks by the reactions given inside it, so an old thread that's popular again climbs
+	// and a new thread doesn't get credit for reactions from before the window. The window is anchored when the
+	// first page is read and carried in the cursor, otherwise it would slide between pages and reorder them
+	orderBy := "threads." + column
+	windowSince := int64(0)
+	if query.Sort == SortTop {
+		window, exists := topWindows[query.Window]
+		if query.Window == "" {
+			window, exists = topWindows["week"], true
+		}
+		if !exists {
+			return "", errors.ErrBadRecord
+		}
+		if window > 0 {
+			windowSince = cursorSince
+			if windowSince == 0 {
+				windowSince = MakeTimestamp() - int64(window / time.Millisecond)
+			}
+			orderBy = windowReactionsExpression(windowSince)
+		}
+	}
+
+	scope := visibleThreads(db, viewer).Preload("Authors").Preload("Posts", func(posts *gorm.DB) *gorm.DB {
+				return visiblePosts(posts, viewer)
+			}).Preload("Posts.Authors").
+			Where("threads.deleted = ?", false).
+			Order(fmt.Sprintf("%s desc, threads.id desc", orderBy)).Limit(query.Limit)
+
+	if query.Timestamp > 0 {
+		scope = scope.Where("threads.timestamp < ?", query.Timestamp)
+	}
+
+	if query.Cursor != "" {
+		scope = scope.Where(fmt.Sprintf("%s < ? OR (%s = ? AND threads.id < ?)", orderBy, orderBy), cursorValue, cursorValue, cursorID)
+	}
+
+	if viewer != nil {
+		var blockedIDs []int
+		GetBlockedIds(db, viewer, &blockedIDs)
+		if len(blockedIDs) > 0 {
+			scope = scope.Where("threads.id NOT IN (SELECT thread_id FROM user_threads WHERE user_id IN (?))", blockedIDs)
+		}
+	}
+
+	scope.Find(threads)
+	if windowSince > 0 {
+		loadWindowReactionsCounts(db, *threads, windowSince)
+	}
+
+	if query.Limit > 0 && len(*threads) == query.Limit {
+		return encodeCursor(&(*threads)[len(*threads) - 1], query.Sort, windowSince), nil
+	}
+	return "", nil
+
+}
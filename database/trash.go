@@ -0,0 +1,287 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/blobstore"
+	"ForumDatabase/errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	RestoreGracePeriod = time.Hour * 24 * 7
+	TrashRetention = time.Hour * 24 * 30
+	// The purge job isn't acting for anyone, so its audit entries have no actor
+	purgeActor = &User{}
+)
+
+// The threads and posts a purge run couldn't remove, the rest were purged regardless
+type PurgeError struct {
+	Failures []error
+}
+
+func (err *PurgeError) Error() string {
+	messages := make([]string, len(err.Failures))
+	for i, failure := range err.Failures {
+		messages[i] = failure.Error()
+	}
+	return fmt.Sprintf("%d purges failed: %s", len(err.Failures), strings.Join(messages, "; "))
+}
+
+// Checks whether the user can restore content they deleted at deletedTimestamp, moderators can restore anything
+func canRestore(user *User, isAuthor bool, deletedTimestamp int64) *errors.UserError {
+	if user.IsModerator() {
+		return nil
+	}
+	if !isAuthor {
+		return errors.ErrNotExist
+	}
+	if MakeTimestamp() - deletedTimestamp > int64(RestoreGracePeriod / time.Millisecond) {
+		return errors.ErrForbidden
+	}
+	return nil
+}
+
+// Undeletes a thread, authors can do this within RestoreGracePeriod of deleting it
+func RestoreThread(db *gorm.DB, user *User, threadId uint) *errors.UserError {
+
+	var thread Thread
+	db.Where("deleted = ?", true).First(&thread, threadId)
+	if thread.ID < 1 {
+		return errors.ErrNotExist
+	}
+
+	var count int64
+	db.Table("user_threads").Where("user_id = ? AND thread_id = ?", user.ID, thread.ID).Count(&count)
+	if err := canRestore(user, count > 0, thread.DeletedTimestamp); err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	if err := tx.Model(&thread).UpdateColumns(map[string]interface{}{"deleted": false, "deleted_timestamp": 0}).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if thread.isVisible() {
+		if err := countThread(tx, findTargetAuthor(db, TargetThread, thread.ID), 1); err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+	}
+	if err := recordAudit(tx, user, AuditRestoreThread, TargetThread, thread.ID, map[string]bool{"deleted": true}, map[string]bool{"deleted": false}); err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+
+}
+
+// Undeletes a post, authors can do this within RestoreGracePeriod of deleting it
+func RestorePost(db *gorm.DB, user *User, postId uint) *errors.UserError {
+
+	var post Post
+	db.Where("deleted = ?", true).First(&post, postId)
+	if post.ID < 1 {
+		return errors.ErrNotExist
+	}
+
+	var count int64
+	db.Table("user_posts").Where("user_id = ? AND post_id = ?", user.ID, post.ID).Count(&count)
+	if err := canRestore(user, count > 0, post.DeletedTimestamp); err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	if err := tx.Model(&post).UpdateColumns(map[string]interface{}{"deleted": false, "deleted_timestamp": 0}).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if post.isVisible() {
+		if err := countPost(tx, findPostThreadId(db, post.ID), findTargetAuthor(db, TargetPost, post.ID), 1); err != nil {
+			tx.Rollback()
+			return errors.ErrSystem
+		}
+	}
+	if err := recordAudit(tx, user, AuditRestorePost, TargetPost, post.ID, map[string]bool{"deleted": true}, map[string]bool{"deleted": false}); err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+
+}
+
+// Gets the user's own threads and posts deleted before timestamp, most recently deleted first
+func GetTrash(db *gorm.DB, user *User, timestamp int64, limit int, threads *[]Thread, posts *[]Post) {
+	db.Joins("INNER JOIN user_threads ON user_threads.thread_id = threads.id").Order("deleted_timestamp desc").Limit(limit).
+			Where("user_threads.user_id = ? AND deleted = ? AND deleted_timestamp > ? AND deleted_timestamp < ?", user.ID, true, 0, timestamp).Find(threads)
+	db.Joins("INNER JOIN user_posts ON user_posts.post_id = posts.id").Order("deleted_timestamp desc").Limit(limit).
+			Where("user_posts.user_id = ? AND deleted = ? AND deleted_timestamp > ? AND deleted_timestamp < ?", user.ID, true, 0, timestamp).Find(posts)
+}
+
+// Gets the first error from statements run in a transaction that's rolled back if any of them failed
+func firstError(results ...*gorm.DB) error {
+	for _, result := range results {
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// Gets the storage keys of attachments matching the query, so their blobs can be removed once the rows are gone
+func findAttachmentKeys(tx *gorm.DB, query string, args ...interface{}) ([]string, error) {
+	var attachments []Attachment
+	if err := tx.Where(query, args...).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, attachment := range attachments {
+		keys = append(keys, attachment.Key)
+	}
+	return keys, nil
+}
+
+// Hard deletes posts along with their join rows and every record pointing at them, replies to them move to the top level
+// Returns the keys of the attachment blobs to remove after the transaction commits
+func purgePosts(tx *gorm.DB, ids []uint) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys, err := findAttachmentKeys(tx, "post_id IN (?)", ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Posts purged with their thread were never deleted themselves, so they still count towards their authors
+	err = firstError(
+		tx.Exec("UPDATE users SET posts_count = posts_count - (SELECT COUNT(*) FROM user_posts INNER JOIN posts ON posts.id = user_posts.post_id " +
+			"WHERE user_posts.user_id = users.id AND posts.id IN (?) AND " + visiblePostCondition + ") " +
+			"WHERE id IN (SELECT user_id FROM user_posts WHERE post_id IN (?))", ids, ids),
+		tx.Model(&Post{}).Where("parent_post_id IN (?) AND id NOT IN (?)", ids, ids).UpdateColumn("parent_post_id", 0),
+		tx.Exec("DELETE FROM thread_posts WHERE post_id IN (?)", ids),
+		tx.Exec("DELETE FROM user_posts WHERE post_id IN (?)", ids),
+		tx.Where("target_type = ? AND target_id IN (?)", TargetPost, ids).Delete(&Reaction{}),
+		tx.Where("target_type = ? AND target_id IN (?)", TargetPost, ids).Delete(&Bookmark{}),
+		tx.Where("target_type = ? AND target_id IN (?)", TargetPost, ids).Delete(&Flag{}),
+		tx.Where("post_id IN (?)", ids).Delete(&Mention{}),
+		tx.Where("post_id IN (?)", ids).Delete(&Notification{}),
+		tx.Where("post_id IN (?) OR source_post_id IN (?)", ids, ids).Delete(&Quote{}),
+		tx.Where("post_id IN (?)", ids).Delete(&Attachment{}),
+		tx.Where("id IN (?)", ids).Delete(&Post{}),
+	)
+	return keys, err
+}
+
+// Hard deletes a thread, its posts and every record pointing at either, returning the attachment blobs to remove
+func purgeThread(tx *gorm.DB, threadId uint) ([]string, error) {
+
+	var postIds []uint
+	if err := tx.Table("thread_posts").Where("thread_id = ?", threadId).Pluck("post_id", &postIds).Error; err != nil {
+		return nil, err
+	}
+	keys, err := purgePosts(tx, postIds)
+	if err != nil {
+		return nil, err
+	}
+
+	threadKeys, err := findAttachmentKeys(tx, "thread_id = ?", threadId)
+	if err != nil {
+		return nil, err
+	}
+
+	polls := "poll_id IN (SELECT id FROM polls WHERE thread_id = ?)"
+	err = firstError(
+		tx.Exec("DELETE FROM user_threads WHERE thread_id = ?", threadId),
+		tx.Where("target_type = ? AND target_id = ?", TargetThread, threadId).Delete(&Reaction{}),
+		tx.Where("target_type = ? AND target_id = ?", TargetThread, threadId).Delete(&Bookmark{}),
+		tx.Where("target_type = ? AND target_id = ?", TargetThread, threadId).Delete(&Flag{}),
+		tx.Where("thread_id = ?", threadId).Delete(&Mention{}),
+		tx.Where("thread_id = ?", threadId).Delete(&Notification{}),
+		tx.Where("thread_id = ?", threadId).Delete(&Attachment{}),
+		tx.Where(polls, threadId).Delete(&PollVote{}),
+		tx.Where(polls, threadId).Delete(&PollOption{}),
+		tx.Where("thread_id = ?", threadId).Delete(&Poll{}),
+		tx.Where("thread_id = ?", threadId).Delete(&ReadPosition{}),
+		tx.Where("thread_id = ?", threadId).Delete(&Draft{}),
+		tx.Where("id = ?", threadId).Delete(&Thread{}),
+	)
+	return append(keys, threadKeys...), err
+
+}
+
+// Runs a purge in its own transaction, removing the attachment blobs only once it has committed
+// A blob left behind by a failed removal just takes up space, nothing points at it any more
+func purgeInTransaction(db *gorm.DB, store blobstore.BlobStore, purge func(tx *gorm.DB) ([]string, error)) error {
+	tx := db.Begin()
+	keys, err := purge(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	for _, key := range keys {
+		store.Delete(key)
+	}
+	return nil
+}
+
+// Hard deletes content that has been deleted for longer than TrashRetention, along with its attachments
+// Threads take their posts with them, content deleted before deletion times were recorded is left alone. Each thread
+// and post is purged and audited in its own transaction, so one that fails doesn't stop the rest, it's reported in a PurgeError
+func PurgeDeletedContent(db *gorm.DB, store blobstore.BlobStore) error {
+
+	cutoff := MakeTimestamp() - int64(TrashRetention / time.Millisecond)
+	var failures []error
+
+	var threadIds []uint
+	if err := db.Model(&Thread{}).Where("deleted = ? AND deleted_timestamp > ? AND deleted_timestamp < ?", true, 0, cutoff).Pluck("id", &threadIds).Error; err != nil {
+		failures = append(failures, fmt.Errorf("finding threads: %v", err))
+	}
+
+	for _, threadId := range threadIds {
+		err := purgeInTransaction(db, store, func(tx *gorm.DB) ([]string, error) {
+			keys, err := purgeThread(tx, threadId)
+			if err != nil {
+				return nil, err
+			}
+			return keys, recordAudit(tx, purgeActor, AuditPurgeThread, TargetThread, threadId, map[string]bool{"deleted": true}, nil)
+		})
+		if err != nil {
+			failures = append(failures, fmt.Errorf("thread %d: %v", threadId, err))
+		}
+	}
+
+	var postIds []uint
+	if err := db.Model(&Post{}).Where("deleted = ? AND deleted_timestamp > ? AND deleted_timestamp < ?", true, 0, cutoff).Pluck("id", &postIds).Error; err != nil {
+		failures = append(failures, fmt.Errorf("finding posts: %v", err))
+	}
+
+	for _, postId := range postIds {
+		err := purgeInTransaction(db, store, func(tx *gorm.DB) ([]string, error) {
+			keys, err := purgePosts(tx, []uint{postId})
+			if err != nil {
+				return nil, err
+			}
+			return keys, recordAudit(tx, purgeActor, AuditPurgePost, TargetPost, postId, map[string]bool{"deleted": true}, nil)
+		})
+		if err != nil {
+			failures = append(failures, fmt.Errorf("post %d: %v", postId, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return &PurgeError{Failures: failures}
+	}
+	return nil
+
+}
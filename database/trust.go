@@ -0,0 +1,171 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+	"ForumDatabase/errors"
+	"ForumDatabase/spam"
+	"time"
+)
+
+// A trust level, what it takes to reach it and what it allows
+// RateLimitMultiplier scales PostRateLimit, 0 meaning no limit
+type TrustLevel struct {
+	Level int `json:"level"`
+	Name string `json:"name"`
+	MinAccountAgeHours int64 `json:"minAccountAgeHours"`
+	MinPosts int64 `json:"minPosts"`
+	MinPostsRead int64 `json:"minPostsRead"`
+	MaxFlagsReceived int64 `json:"maxFlagsReceived"`
+	PostLinks bool `json:"postLinks"`
+	Attachments bool `json:"attachments"`
+	CreateThreads bool `json:"createThreads"`
+	RateLimitMultiplier float64 `json:"rateLimitMultiplier"`
+}
+
+// Where a user stands, the next level being nil once they've reached the top
+type TrustProgress struct {
+	Level TrustLevel `json:"level"`
+	Override *int `json:"override"`
+	AccountAgeHours int64 `json:"accountAgeHours"`
+	Posts int64 `json:"posts"`
+	PostsRead int64 `json:"postsRead"`
+	FlagsReceived int64 `json:"flagsReceived"`
+	Next *TrustLevel `json:"next"`
+}
+
+var (
+	// Levels in ascending order, users get the highest one whose requirements they all meet
+	// Only moderators can start threads at level 0, so an empty forum needs them to post first when these are switched on
+	DefaultTrustLevels = []TrustLevel{
+		{Level: 0, Name: "new", RateLimitMultiplier: 0.5},
+		{Level: 1, Name: "basic", MinAccountAgeHours: 24, MinPosts: 1, MinPostsRead: 20, MaxFlagsReceived: 3,
+			PostLinks: true, CreateThreads: true, RateLimitMultiplier: 1},
+		{Level: 2, Name: "member", MinAccountAgeHours: 24 * 7, MinPosts: 10, MinPostsRead: 100, MaxFlagsReceived: 3,
+			PostLinks: true, Attachments: true, CreateThreads: true, RateLimitMultiplier: 2},
+		{Level: 3, Name: "regular", MinAccountAgeHours: 24 * 30, MinPosts: 50, MinPostsRead: 500, MaxFlagsReceived: 1,
+			PostLinks: true, Attachments: true, CreateThreads: true, RateLimitMultiplier: 4},
+	}
+	// The levels in force, nil (the default) leaves everyone unrestricted. Set from enable_trust_levels
+	TrustLevels []TrustLevel
+	PostRateLimit = 10
+	PostRateWindow = time.Minute
+	unrestricted = TrustLevel{Level: -1, Name: "unrestricted", PostLinks: true, Attachments: true, CreateThreads: true}
+)
+
+func (level *TrustLevel) reachedBy(progress *TrustProgress) bool {
+	return progress.AccountAgeHours >= level.MinAccountAgeHours && progress.Posts >= level.MinPosts &&
+		progress.PostsRead >= level.MinPostsRead && progress.FlagsReceived <= level.MaxFlagsReceived
+}
+
+// Works out the user's trust level from their activity, unless an admin has overridden it
+func GetTrustProgress(db *gorm.DB, user *User) *TrustProgress {
+
+	progress := TrustProgress{Override: user.TrustLevelOverride, AccountAgeHours: int64(time.Since(user.CreatedAt) / time.Hour), PostsRead: user.PostsRead}
+	db.Table("user_posts").Where("user_id = ?", user.ID).Count(&progress.Posts)
+	db.Model(&Flag{}).Where("author_id = ?", user.ID).Count(&progress.FlagsReceived)
+
+	if len(TrustLevels) == 0 {
+		progress.Level = unrestricted
+		return &progress
+	}
+
+	progress.Level = TrustLevels[0]
+	for i := 1; i < len(TrustLevels); i++ {
+		if !TrustLevels[i].reachedBy(&progress) {
+			next := TrustLevels[i]
+			progress.Next = &next
+			break
+		}
+		progress.Level = TrustLevels[i]
+	}
+
+	if user.TrustLevelOverride != nil {
+		for _, level := range TrustLevels {
+			if level.Level == *user.TrustLevelOverride {
+				progress.Level = level
+			}
+		}
+	}
+
+	return &progress
+
+}
+
+// Gets what the user is allowed to do, moderators being unrestricted
+func trustLevel(db *gorm.DB, user *User) TrustLevel {
+	if user.IsModerator() {
+		return unrestricted
+	}
+	return GetTrustProgress(db, user).Level
+}
+
+// Checks the content against what the user's trust level allows before it's posted
+func checkTrust(db *gorm.DB, user *User, content string, newThread bool) *errors.UserError {
+
+	level := trustLevel(db, user)
+
+	if newThread && !level.CreateThreads {
+		return errors.ErrTrustLevel
+	}
+
+	if !level.PostLinks && len(spam.Links(content)) > 0 {
+		return errors.ErrTrustLevel
+	}
+
+	if level.RateLimitMultiplier > 0 && PostRateLimit > 0 {
+		since := MakeTimestamp() - int64(PostRateWindow / time.Millisecond)
+		var recent int64
+		err := db.Raw("SELECT (SELECT COUNT(*) FROM posts INNER JOIN user_posts ON user_posts.post_id = posts.id WHERE user_posts.user_id = ? AND posts.timestamp > ?) + " +
+				"(SELECT COUNT(*) FROM threads INNER JOIN user_threads ON user_threads.thread_id = threads.id WHERE user_threads.user_id = ? AND threads.timestamp > ?)",
+				user.ID, since, user.ID, since).Row().Scan(&recent)
+		if err != nil {
+			return errors.ErrSystem
+		}
+		if float64(recent) >= float64(PostRateLimit) * level.RateLimitMultiplier {
+			return errors.ErrRateLimited
+		}
+	}
+
+	return nil
+
+}
+
+// Checks whether the user's trust level lets them upload attachments
+func canAttach(db *gorm.DB, user *User) bool {
+	return trustLevel(db, user).Attachments
+}
+
+// Pins the user to a trust level, or goes back to working it out from their activity when level is nil
+func SetTrustLevelOverride(db *gorm.DB, admin *User, targetId uint, level *int) *errors.UserError {
+
+	target, err := FindUser(db, targetId)
+	if err != nil {
+		return err
+	}
+
+	if level != nil {
+		valid := false
+		for _, trustLevel := range TrustLevels {
+			valid = valid || trustLevel.Level == *level
+		}
+		if !valid {
+			return errors.ErrBadRecord
+		}
+	}
+
+	tx := db.Begin()
+	if err := tx.Model(target).UpdateColumn("trust_level_override", level).Error; err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := recordAudit(tx, admin, AuditSetTrustLevel, TargetUser, target.ID,
+		map[string]*int{"override": target.TrustLevelOverride}, map[string]*int{"override": level}); err != nil {
+		tx.Rollback()
+		return errors.ErrSystem
+	}
+	if err := tx.Commit().Error; err != nil {
+		return errors.ErrSystem
+	}
+	return nil
+
+}
@@ -1,21 +1,64 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"net/http"
+)
 
+// An error that can be shown to the user, Code is stable across releases and is what clients should match on
 type UserError struct {
 	Err error
-	Code int
+	Code string
+	Status int
+	Details []Detail
+}
+
+// Points at the field that caused an error and the rule it broke, Params fill in the localized message
+type Detail struct {
+	Field string `json:"field"`
+	Rule string `json:"rule"`
+	Params map[string]interface{} `json:"params,omitempty"`
+	Message string `json:"message"`
 }
 
 var (
-	ErrNotExist = &UserError{errors.New("Record does not exist"), 1}
-	ErrBadRecord = &UserError{errors.New("Bad record"), 2}
-	ErrSystem = &UserError{errors.New("System error"), 3}
-	ErrExists = &UserError{errors.New("Record already exists"), 4}
-	ErrTooShort = &UserError{errors.New("Input too short"), 5}
-	ErrContainSpaces = &UserError{errors.New("Input contains spaces"), 6}
+	ErrNotExist = &UserError{Err: errors.New("Record does not exist"), Code: "not_exist", Status: http.StatusNotFound}
+	ErrBadRecord = &UserError{Err: errors.New("Bad record"), Code: "bad_record", Status: http.StatusBadRequest}
+	ErrSystem = &UserError{Err: errors.New("System error"), Code: "system", Status: http.StatusInternalServerError}
+	ErrExists = &UserError{Err: errors.New("Record already exists"), Code: "exists", Status: http.StatusConflict}
+	ErrTooShort = &UserError{Err: errors.New("Input too short"), Code: "too_short", Status: http.StatusBadRequest}
+	ErrContainSpaces = &UserError{Err: errors.New("Input contains spaces"), Code: "contains_spaces", Status: http.StatusBadRequest}
+	ErrTooLarge = &UserError{Err: errors.New("Input too large"), Code: "too_large", Status: http.StatusRequestEntityTooLarge}
+	ErrUnsupportedType = &UserError{Err: errors.New("Unsupported file type"), Code: "unsupported_type", Status: http.StatusUnsupportedMediaType}
+	ErrQuotaExceeded = &UserError{Err: errors.New("Storage quota exceeded"), Code: "quota_exceeded", Status: http.StatusForbidden}
+	ErrBlocked = &UserError{Err: errors.New("User has blocked you"), Code: "blocked", Status: http.StatusForbidden}
+	ErrSpam = &UserError{Err: errors.New("Content was rejected as spam"), Code: "spam", Status: http.StatusUnprocessableEntity}
+	ErrForbidden = &UserError{Err: errors.New("Not allowed"), Code: "forbidden", Status: http.StatusForbidden}
+	ErrTrustLevel = &UserError{Err: errors.New("Trust level too low"), Code: "trust_level", Status: http.StatusForbidden}
+	ErrRateLimited = &UserError{Err: errors.New("Posting too often"), Code: "rate_limited", Status: http.StatusTooManyRequests}
+	ErrBanned = &UserError{Err: errors.New("Account is banned"), Code: "banned", Status: http.StatusForbidden}
+	ErrSuspended = &UserError{Err: errors.New("Account is suspended"), Code: "suspended", Status: http.StatusForbidden}
+	ErrInvalidRequest = &UserError{Err: errors.New("Request could not be read"), Code: "invalid_request", Status: http.StatusBadRequest}
+	ErrUnauthorized = &UserError{Err: errors.New("Not logged in"), Code: "unauthorized", Status: http.StatusUnauthorized}
+	ErrValidation = &UserError{Err: errors.New("Request has invalid fields"), Code: "validation_failed", Status: http.StatusBadRequest}
+	ErrBadCredentials = &UserError{Err: errors.New("Wrong username or password"), Code: "bad_credentials", Status: http.StatusUnauthorized}
 )
 
 func (msg *UserError) Error() string {
 	return msg.Err.Error()
 }
+
+// Returns a copy of the error pointing at a field, leaving the shared catalog error untouched
+func (msg *UserError) WithDetail(field string, rule string, params map[string]interface{}) *UserError {
+	details := make([]Detail, len(msg.Details), len(msg.Details) + 1)
+	copy(details, msg.Details)
+	copied := *msg
+	copied.Details = append(details, Detail{Field: field, Rule: rule, Params: params})
+	return &copied
+}
+
+// Checks whether the target is the same catalog error, so copies made by WithDetail still match their original
+func (msg *UserError) Is(target error) bool {
+	other, ok := target.(*UserError)
+	return ok && msg != nil && other != nil && msg.Code == other.Code
+}
@@ -0,0 +1,46 @@
+package errors
+
+import "testing"
+
+func TestMatchLanguage(t *testing.T) {
+
+	if language := MatchLanguage(""); language != DefaultLanguage {
+		t.Error("Expected an empty header to fall back to the default language, got ", language)
+	}
+
+	if language := MatchLanguage("fr-CH, fr;q=0.9, de;q=0.7, en;q=0.8"); language != "en" {
+		t.Error("Expected the highest quality supported language, got ", language)
+	}
+
+	if language := MatchLanguage("es-MX"); language != "es" {
+		t.Error("Expected regional variants to match their base language, got ", language)
+	}
+
+	if language := MatchLanguage("de;q=0, es;q=0.5"); language != "es" {
+		t.Error("Expected languages with zero quality to be skipped, got ", language)
+	}
+
+}
+
+func TestWithDetail(t *testing.T) {
+
+	err := ErrTooShort.WithDetail("title", "min_length", map[string]interface{}{"min": 10})
+
+	if len(ErrTooShort.Details) != 0 {
+		t.Error("Expected the catalog error to be left untouched")
+	}
+
+	if !err.Is(ErrTooShort) || err.Is(ErrTooLarge) {
+		t.Error("Expected the copy to match only its original")
+	}
+
+	details := err.LocalizedDetails("de")
+	if len(details) != 1 || details[0].Message != "title muss mindestens 10 Zeichen lang sein" {
+		t.Error("Expected a localized detail message, got ", details)
+	}
+
+	if message := err.Message("xx"); message != "Input too short" {
+		t.Error("Expected unknown languages to fall back to the default, got ", message)
+	}
+
+}
@@ -0,0 +1,213 @@
+package errors
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DefaultLanguage = "en"
+
+// Messages for each error code and each validation rule by language, {name} placeholders are filled from the detail
+var (
+	Messages = map[string]map[string]string{
+		"en": {
+			"not_exist": "Record does not exist",
+			"bad_record": "Bad record",
+			"system": "System error",
+			"exists": "Record already exists",
+			"too_short": "Input too short",
+			"contains_spaces": "Input contains spaces",
+			"too_large": "Input too large",
+			"unsupported_type": "Unsupported file type",
+			"quota_exceeded": "Storage quota exceeded",
+			"blocked": "User has blocked you",
+			"spam": "Content was rejected as spam",
+			"forbidden": "Not allowed",
+			"trust_level": "Trust level too low",
+			"rate_limited": "Posting too often",
+			"banned": "Account is banned",
+			"suspended": "Account is suspended",
+			"invalid_request": "Request could not be read",
+			"unauthorized": "Not logged in",
+			"bad_credentials": "Wrong username or password",
+			"validation_failed": "Request has invalid fields",
+		},
+		"es": {
+			"not_exist": "El registro no existe",
+			"bad_record": "Registro no válido",
+			"system": "Error del sistema",
+			"exists": "El registro ya existe",
+			"too_short": "Entrada demasiado corta",
+			"contains_spaces": "La entrada contiene espacios",
+			"too_large": "Entrada demasiado grande",
+			"unsupported_type": "Tipo de archivo no admitido",
+			"quota_exceeded": "Cuota de almacenamiento superada",
+			"blocked": "El usuario te ha bloqueado",
+			"spam": "El contenido fue rechazado como spam",
+			"forbidden": "No permitido",
+			"trust_level": "Nivel de confianza demasiado bajo",
+			"rate_limited": "Publicas con demasiada frecuencia",
+			"banned": "La cuenta está bloqueada",
+			"suspended": "La cuenta está suspendida",
+			"invalid_request": "No se pudo leer la solicitud",
+			"unauthorized": "No has iniciado sesión",
+			"bad_credentials": "Usuario o contraseña incorrectos",
+			"validation_failed": "La solicitud tiene campos no válidos",
+		},
+		"de": {
+			"not_exist": "Eintrag existiert nicht",
+			"bad_record": "Ungültiger Eintrag",
+			"system": "Systemfehler",
+			"exists": "Eintrag existiert bereits",
+			"too_short": "Eingabe zu kurz",
+			"contains_spaces": "Eingabe enthält Leerzeichen",
+			"too_large": "Eingabe zu groß",
+			"unsupported_type": "Dateityp wird nicht unterstützt",
+			"quota_exceeded": "Speicherkontingent überschritten",
+			"blocked": "Der Benutzer hat dich blockiert",
+			"spam": "Inhalt wurde als Spam abgelehnt",
+			"forbidden": "Nicht erlaubt",
+			"trust_level": "Vertrauensstufe zu niedrig",
+			"rate_limited": "Zu viele Beiträge in kurzer Zeit",
+			"banned": "Konto ist gesperrt",
+			"suspended": "Konto ist vorübergehend gesperrt",
+			"invalid_request": "Anfrage konnte nicht gelesen werden",
+			"unauthorized": "Nicht angemeldet",
+			"bad_credentials": "Falscher Benutzername oder falsches Passwort",
+			"validation_failed": "Die Anfrage enthält ungültige Felder",
+		},
+	}
+
+	RuleMessages = map[string]map[string]string{
+		"en": {
+			"min_length": "{field} must be at least {min} characters",
+			"max_length": "{field} must be at most {max} characters",
+			"no_spaces": "{field} can't contain spaces",
+			"min_count": "{field} needs at least {min} entries",
+			"max_count": "{field} can have at most {max} entries",
+			"required": "{field} is required",
+			"min_value": "{field} must be at least {min}",
+			"charset": "{field} contains characters that aren't allowed",
+			"one_of": "{field} must be one of {allowed}",
+			"unique": "{field} can't contain duplicates",
+			"reserved": "{field} is reserved",
+			"password_classes": "{field} needs at least one character of each kind: {classes}",
+			"breached": "{field} has appeared in a data breach, choose another",
+			"contains_username": "{field} can't contain the username",
+		},
+		"es": {
+			"min_length": "{field} debe tener al menos {min} caracteres",
+			"max_length": "{field} debe tener como máximo {max} caracteres",
+			"no_spaces": "{field} no puede contener espacios",
+			"min_count": "{field} necesita al menos {min} elementos",
+			"max_count": "{field} puede tener como máximo {max} elementos",
+			"required": "{field} es obligatorio",
+			"min_value": "{field} debe ser al menos {min}",
+			"charset": "{field} contiene caracteres no permitidos",
+			"one_of": "{field} debe ser uno de {allowed}",
+			"unique": "{field} no puede contener duplicados",
+			"reserved": "{field} está reservado",
+			"password_classes": "{field} necesita al menos un carácter de cada tipo: {classes}",
+			"breached": "{field} ha aparecido en una filtración de datos, elige otra",
+			"contains_username": "{field} no puede contener el nombre de usuario",
+		},
+		"de": {
+			"min_length": "{field} muss mindestens {min} Zeichen lang sein",
+			"max_length": "{field} darf höchstens {max} Zeichen lang sein",
+			"no_spaces": "{field} darf keine Leerzeichen enthalten",
+			"min_count": "{field} braucht mindestens {min} Einträge",
+			"max_count": "{field} darf höchstens {max} Einträge haben",
+			"required": "{field} ist erforderlich",
+			"min_value": "{field} muss mindestens {min} sein",
+			"charset": "{field} enthält unzulässige Zeichen",
+			"one_of": "{field} muss einer von {allowed} sein",
+			"unique": "{field} darf keine Duplikate enthalten",
+			"reserved": "{field} ist reserviert",
+			"password_classes": "{field} braucht mindestens ein Zeichen jeder Art: {classes}",
+			"breached": "{field} ist in einem Datenleck aufgetaucht, wähle ein anderes",
+			"contains_username": "{field} darf den Benutzernamen nicht enthalten",
+		},
+	}
+)
+
+// Picks the best supported language from an Accept-Language header, falling back to DefaultLanguage
+func MatchLanguage(header string) string {
+
+	type candidate struct {
+		language string
+		quality float64
+	}
+	var candidates []candidate
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		language := strings.ToLower(strings.TrimSpace(fields[0]))
+		if index := strings.Index(language, "-"); index > 0 {
+			language = language[:index]
+		}
+		if _, supported := Messages[language]; !supported {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = value
+				}
+			}
+		}
+		if quality > 0 {
+			candidates = append(candidates, candidate{language, quality})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return DefaultLanguage
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+	return candidates[0].language
+
+}
+
+func lookup(catalog map[string]map[string]string, language string, key string) (string, bool) {
+	if message, exists := catalog[language][key]; exists {
+		return message, true
+	}
+	message, exists := catalog[DefaultLanguage][key]
+	return message, exists
+}
+
+func interpolate(message string, field string, params map[string]interface{}) string {
+	message = strings.Replace(message, "{field}", field, -1)
+	for name, value := range params {
+		message = strings.Replace(message, "{" + name + "}", fmt.Sprint(value), -1)
+	}
+	return message
+}
+
+// The error message in the given language
+func (msg *UserError) Message(language string) string {
+	if message, exists := lookup(Messages, language, msg.Code); exists {
+		return message
+	}
+	return msg.Error()
+}
+
+// The details with their messages filled in for the given language
+func (msg *UserError) LocalizedDetails(language string) []Detail {
+	details := make([]Detail, len(msg.Details))
+	for i, detail := range msg.Details {
+		details[i] = detail
+		if message, exists := lookup(RuleMessages, language, detail.Rule); exists {
+			details[i].Message = interpolate(message, detail.Field, detail.Params)
+		} else {
+			details[i].Message = msg.Message(language)
+		}
+	}
+	return details
+}
@@ -2,7 +2,10 @@ package helpers
 
 import (
 	"strings"
+	"regexp"
+	"strconv"
 	"ForumDatabase/errors"
+	"unicode/utf8"
 )
 
 var (
@@ -10,50 +13,91 @@ var (
 	MinLengthContent = 16
 	MinLengthPassword = 8
 	MinLengthUsername = 6
+	MaxLengthTitle = 150
+	MaxLengthContent = 16000
+	MaxLengthPassword = 72
+	MaxLengthUsername = 32
+	MaxLengthShortText = 255
+	MaxMentions = 10
+	MinPollOptions = 2
+	MaxPollOptions = 20
+	AllowedReactions = []string{"+1", "heart", "laugh", "hooray", "confused", "eyes"}
+)
+
+var (
+	// Usernames are limited to what an @mention can refer to, which never ends in the . or - that MentionSpans trims
+	UsernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]*[A-Za-z0-9_]$`)
+	// An @ that doesn't follow a word character or another @, so email addresses aren't mentions. Use MentionSpans
+	// rather than matching this directly, it also trims the punctuation a mention can end a sentence with
+	MentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.\-]+)`)
+	codePattern = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+	quotePattern = regexp.MustCompile(`(?s)\[quote=(\d+)\](.*?)\[/quote\]`)
+	// Attachment names leave out path separators and the characters common filesystems reserve
+	FilenamePattern = regexp.MustCompile(`^[^/\\:*?"<>|]+$`)
 )
 
 // TODO: Need to add profanity filter
 func ValidateTitle(input string) *errors.UserError {
-	trimmed := strings.Trim(input, " ")
-	if len(trimmed) < MinLengthTitle {
-		return errors.ErrTooShort
-	} else {
-		return nil
+	validator := new (Validator)
+	validator.Length("title", input, MinLengthTitle, MaxLengthTitle)
+	validator.Printable("title", input, false)
+	return validator.Error()
+}
+
+// Checks optional free text, like a flag reason, fits in MaxLengthShortText characters
+func ValidateShortText(field string, input string) *errors.UserError {
+	validator := new (Validator)
+	validator.Length(field, input, 0, MaxLengthShortText)
+	validator.Printable(field, input, true)
+	return validator.Error()
+}
+
+// Checks an uploaded file's name is a plain name that's safe to hand back in a download
+func ValidateFilename(input string) *errors.UserError {
+	validator := new (Validator)
+	validator.Length("filename", input, 1, MaxLengthShortText)
+	validator.Printable("filename", input, false)
+	if !validator.Failed("filename") {
+		validator.Charset("filename", input, FilenamePattern)
+		if input == "." || input == ".." {
+			validator.add("filename", "charset", nil)
+		}
 	}
+	return validator.Error()
 }
 
 // TODO: Need to add profanity filter
 func ValidateContent(input string) *errors.UserError {
-	trimmed := strings.Trim(input, " ")
-	if len(trimmed) < MinLengthContent {
-		return errors.ErrTooShort
-	} else {
-		return nil
-	}
+	validator := new (Validator)
+	validator.Length("content", input, MinLengthContent, MaxLengthContent)
+	validator.Printable("content", input, true)
+	return validator.Error()
 }
 
-func ValidatePassword(input string) *errors.UserError {
-
-	if strings.Contains(input, " ") {
-		return errors.ErrContainSpaces
-	} else if len(input) < MinLengthPassword {
-		return errors.ErrTooShort
-	} else {
-		return nil
+// bcrypt only uses the first 72 bytes of a password, so unlike everything else the maximum is in bytes
+func ValidatePassword(input string, username string) *errors.UserError {
+	validator := new (Validator)
+	validator.NoSpaces("password", input)
+	if utf8.RuneCountInString(input) < MinLengthPassword {
+		validator.add("password", "min_length", map[string]interface{}{"min": MinLengthPassword})
+	} else if len(input) > MaxLengthPassword {
+		validator.add("password", "max_length", map[string]interface{}{"max": MaxLengthPassword})
 	}
-
+	checkPasswordPolicy(validator, input, username)
+	return validator.Error()
 }
 
 func ValidateUsername(input string) *errors.UserError {
-
-	if strings.Contains(input, " ") {
-		return errors.ErrContainSpaces
-	} else if len(input) < MinLengthUsername {
-		return errors.ErrTooShort
-	} else {
-		return nil
+	validator := new (Validator)
+	validator.NoSpaces("username", input)
+	validator.Length("username", input, MinLengthUsername, MaxLengthUsername)
+	if !validator.Failed("username") {
+		validator.Charset("username", input, UsernamePattern)
 	}
-
+	if isReservedUsername(input) {
+		validator.add("username", "reserved", nil)
+	}
+	return validator.Error()
 }
 
 // Checks if an int is in a slice
@@ -64,4 +108,77 @@ func IntInSlice(list []int, value int) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// Checks if a string is in a slice
+func StringInSlice(list []string, value string) bool {
+	for _, listValue := range list {
+		if listValue == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if a uint is in a slice
+func UintInSlice(list []uint, value uint) bool {
+	for _, listValue := range list {
+		if listValue == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Finds where each @mention is in text, as the start and end of the @ and username
+// Trailing dots and dashes are left out, so "@bob." mentions bob
+func MentionSpans(text string) [][]int {
+	var spans [][]int
+	for _, match := range MentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		username := strings.TrimRight(text[match[2]:match[3]], ".-")
+		if username != "" {
+			spans = append(spans, []int{match[2] - 1, match[2] + len(username)})
+		}
+	}
+	return spans
+}
+
+// Finds the unique @username mentions in content outside of code, capped at MaxMentions
+func ParseMentions(content string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	stripped := codePattern.ReplaceAllString(content, " ")
+	for _, span := range MentionSpans(stripped) {
+		username := stripped[span[0] + 1:span[1]]
+		key := strings.ToLower(username)
+		if seen[key] {
+			continue
+		}
+		if len(usernames) >= MaxMentions {
+			break
+		}
+		seen[key] = true
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
+
+// Finds each [quote=id]...[/quote] block outside of code, as the start and end of the block, the id and the quoted text
+// Code is blanked out rather than removed so the positions still line up with text
+func QuoteSpans(text string) [][]int {
+	masked := codePattern.ReplaceAllStringFunc(text, func(code string) string {
+		return strings.Repeat(" ", len(code))
+	})
+	return quotePattern.FindAllStringSubmatchIndex(masked, -1)
+}
+
+// Finds the unique post ids referenced by [quote=id] blocks outside of code
+func ParseQuotes(content string) []uint {
+	var ids []uint
+	for _, span := range QuoteSpans(content) {
+		if id, err := strconv.ParseUint(content[span[2]:span[3]], 10, 64); err == nil && !UintInSlice(ids, uint(id)) {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
@@ -1,6 +1,9 @@
 package helpers
 
-import "testing"
+import (
+	"testing"
+	"strings"
+)
 
 func TestIntInSlice(t *testing.T) {
 
@@ -14,4 +17,129 @@ func TestIntInSlice(t *testing.T) {
 		t.Error("Expected result to be false")
 	}
 
-}
\ No newline at end of file
+}
+
+func TestParseMentions(t *testing.T) {
+
+	mentions := ParseMentions("@goldtime34 see above, cc @Sholomobo2. Not `@inline` or email@example.com, @goldtime34 again")
+
+	if len(mentions) != 2 || mentions[0] != "goldtime34" || mentions[1] != "Sholomobo2" {
+		t.Error("Unexpected mentions: ", mentions)
+	}
+
+	MaxMentions = 1
+	defer func() { MaxMentions = 10 }()
+
+	if mentions := ParseMentions("@first @second @third"); len(mentions) != 1 {
+		t.Error("Expected mentions to be capped: ", mentions)
+	}
+
+}
+
+func TestParseQuotes(t *testing.T) {
+
+	quotes := ParseQuotes("[quote=12]first[/quote] and [quote=7]second[/quote] [quote=12]again[/quote]")
+
+	if len(quotes) != 2 || quotes[0] != 12 || quotes[1] != 7 {
+		t.Error("Unexpected quotes: ", quotes)
+	}
+
+	if quotes := ParseQuotes("```\n[quote=3]in a fence[/quote]\n```\nand `[quote=4]in code[/quote]`"); len(quotes) != 0 {
+		t.Error("Expected quotes inside code to be ignored: ", quotes)
+	}
+
+}
+
+func TestValidateUsername(t *testing.T) {
+
+	err := ValidateUsername("a b")
+	if err == nil || len(err.Details) != 2 {
+		t.Error("Expected both the space and the length to be reported, got ", err)
+	}
+
+	if err := ValidateUsername("name<script>"); err == nil || err.Details[0].Rule != "charset" {
+		t.Error("Expected characters outside the username charset to be rejected, got ", err)
+	}
+
+	if err := ValidateUsername("good.name_1"); err != nil {
+		t.Error("Expected a valid username to pass, got ", err)
+	}
+
+	for _, username := range []string{"trailing.", "trailing-"} {
+		if err := ValidateUsername(username); err == nil || err.Details[0].Rule != "charset" {
+			t.Error("Expected a username that can't be mentioned to be rejected: ", username)
+		}
+	}
+
+}
+
+func TestValidateTitleCountsCharacters(t *testing.T) {
+
+	// Ten characters but twenty bytes
+	if err := ValidateTitle("éééééééééé"); err != nil {
+		t.Error("Expected the title length to be counted in characters, got ", err)
+	}
+
+	if err := ValidateTitle("A title\x00with a null"); err == nil || err.Details[0].Rule != "charset" {
+		t.Error("Expected control characters to be rejected, got ", err)
+	}
+
+}
+
+func TestValidateFilename(t *testing.T) {
+
+	if err := ValidateFilename("holiday photo (1).png"); err != nil {
+		t.Error("Expected a plain filename to pass, got ", err)
+	}
+
+	for _, filename := range []string{"", "..", "../../etc/passwd", "C:\\boot.ini", "line\nbreak.txt", strings.Repeat("a", MaxLengthShortText + 1)} {
+		if err := ValidateFilename(filename); err == nil {
+			t.Error("Expected the filename to be rejected: ", filename)
+		}
+	}
+
+}
+
+func TestValidatorCollectsViolations(t *testing.T) {
+
+	validator := new (Validator)
+	validator.Required("title", false)
+	validator.Length("content", "short", 10, 0)
+	validator.Length("content", "short", 10, 0)
+	validator.OneOf("emoji", "nope", AllowedReactions)
+
+	err := validator.Error()
+	if err == nil || len(err.Details) != 3 {
+		t.Error("Expected three distinct violations, got ", err)
+	}
+
+	if err := new (Validator).Error(); err != nil {
+		t.Error("Expected no error without violations")
+	}
+
+}
+
+func TestValidatePasswordPolicy(t *testing.T) {
+
+	PasswordClasses = []string{PasswordUpper, PasswordDigit}
+	defer func() { PasswordClasses = nil }()
+
+	err := ValidatePassword("lowercaseonly", "")
+	if err == nil || err.Details[0].Rule != "password_classes" || err.Details[0].Params["classes"] != "upper, digit" {
+		t.Error("Expected the missing character classes to be reported together, got ", err)
+	}
+
+	// Seven characters but thirteen bytes
+	if err := ValidatePassword("ÄÖÜäöü1", ""); err == nil || err.Details[0].Rule != "min_length" {
+		t.Error("Expected the minimum length to count characters, got ", err)
+	}
+
+	if err := ValidatePassword("Mygoldtime34!", "GoldTime34"); err == nil || err.Details[0].Rule != "contains_username" {
+		t.Error("Expected a password containing the username to be rejected, got ", err)
+	}
+
+	if err := ValidateUsername("Administrator"); err == nil || err.Details[0].Rule != "reserved" {
+		t.Error("Expected reserved usernames to be rejected regardless of case, got ", err)
+	}
+
+}
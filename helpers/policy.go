@@ -0,0 +1,210 @@
+package helpers
+
+import (
+	"bufio"
+	"ForumDatabase/config"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	PasswordLower = "lower"
+	PasswordUpper = "upper"
+	PasswordDigit = "digit"
+	PasswordSymbol = "symbol"
+)
+
+var (
+	ReservedUsernames = []string{"admin", "administrator", "moderator", "system", "root", "support"}
+	PasswordClasses []string
+	RejectUsernameInPassword = true
+	breachedPasswords map[string]bool
+)
+
+// The limits clients can check input against before submitting it
+type ValidationRules struct {
+	Title LengthRule `json:"title"`
+	Content LengthRule `json:"content"`
+	ShortText LengthRule `json:"shortText"`
+	Username UsernameRule `json:"username"`
+	Password PasswordRule `json:"password"`
+	Poll PollRule `json:"poll"`
+	Reactions []string `json:"reactions"`
+}
+
+type LengthRule struct {
+	MinLength int `json:"minLength"`
+	MaxLength int `json:"maxLength"`
+}
+
+type UsernameRule struct {
+	LengthRule
+	Pattern string `json:"pattern"`
+	Reserved []string `json:"reserved"`
+}
+
+type PasswordRule struct {
+	LengthRule
+	RequiredClasses []string `json:"requiredClasses"`
+	RejectUsername bool `json:"rejectUsername"`
+	RejectBreached bool `json:"rejectBreached"`
+}
+
+type PollRule struct {
+	MinOptions int `json:"minOptions"`
+	MaxOptions int `json:"maxOptions"`
+	MaxLength int `json:"maxLength"`
+}
+
+// The most a text column can hold, text is 65535 bytes and a character takes up to 4
+const maxStoredContentLength = 16000
+
+// bcrypt ignores everything after the first 72 bytes
+const maxStoredPasswordLength = 72
+
+// Sets a length limit pair from the policy, checking they still make sense together
+func applyLengths(name string, min int, max int, minTarget *int, maxTarget *int, limit int) error {
+	if min > 0 {
+		*minTarget = min
+	}
+	if max > 0 {
+		*maxTarget = max
+	}
+	if *maxTarget < *minTarget {
+		return fmt.Errorf("validation: max_length_%s (%d) is less than min_length_%s (%d)", name, *maxTarget, name, *minTarget)
+	}
+	if limit > 0 && *maxTarget > limit {
+		return fmt.Errorf("validation: max_length_%s can't be more than %d", name, limit)
+	}
+	return nil
+}
+
+// Applies the validation section of the config on top of the defaults
+func ApplyPolicy(policy *config.ValidationPolicy) error {
+
+	if err := applyLengths("title", policy.MinLengthTitle, policy.MaxLengthTitle, &MinLengthTitle, &MaxLengthTitle, MaxLengthShortText); err != nil {
+		return err
+	}
+	if err := applyLengths("content", policy.MinLengthContent, policy.MaxLengthContent, &MinLengthContent, &MaxLengthContent, maxStoredContentLength); err != nil {
+		return err
+	}
+	if err := applyLengths("username", policy.MinLengthUsername, policy.MaxLengthUsername, &MinLengthUsername, &MaxLengthUsername, MaxLengthShortText); err != nil {
+		return err
+	}
+	if err := applyLengths("password", policy.MinLengthPassword, policy.MaxLengthPassword, &MinLengthPassword, &MaxLengthPassword, maxStoredPasswordLength); err != nil {
+		return err
+	}
+
+	if policy.UsernamePattern != "" {
+		pattern, err := regexp.Compile(policy.UsernamePattern)
+		if err != nil {
+			return fmt.Errorf("validation: bad username_pattern: %v", err)
+		}
+		UsernamePattern = pattern
+	}
+	if len(policy.ReservedUsernames) > 0 {
+		ReservedUsernames = policy.ReservedUsernames
+	}
+
+	for _, class := range policy.PasswordClasses {
+		if !StringInSlice([]string{PasswordLower, PasswordUpper, PasswordDigit, PasswordSymbol}, class) {
+			return fmt.Errorf("validation: unknown password class %q", class)
+		}
+	}
+	PasswordClasses = policy.PasswordClasses
+	RejectUsernameInPassword = !policy.AllowUsernameInPassword
+
+	if policy.BreachedPasswordsFile != "" {
+		if err := LoadBreachedPasswords(policy.BreachedPasswordsFile); err != nil {
+			return fmt.Errorf("validation: can't load breached_passwords_file: %v", err)
+		}
+	}
+
+	return nil
+
+}
+
+// Gets the rules currently in force
+func Rules() ValidationRules {
+	return ValidationRules{
+		Title: LengthRule{MinLengthTitle, MaxLengthTitle},
+		Content: LengthRule{MinLengthContent, MaxLengthContent},
+		ShortText: LengthRule{0, MaxLengthShortText},
+		Username: UsernameRule{LengthRule{MinLengthUsername, MaxLengthUsername}, UsernamePattern.String(), ReservedUsernames},
+		Password: PasswordRule{LengthRule{MinLengthPassword, MaxLengthPassword}, PasswordClasses, RejectUsernameInPassword, len(breachedPasswords) > 0},
+		Poll: PollRule{MinPollOptions, MaxPollOptions, MaxLengthShortText},
+		Reactions: AllowedReactions,
+	}
+}
+
+// Loads the breached password list, one password per line, replacing any list loaded before
+func LoadBreachedPasswords(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			passwords[strings.ToLower(password)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	breachedPasswords = passwords
+	return nil
+}
+
+func isReservedUsername(username string) bool {
+	for _, reserved := range ReservedUsernames {
+		if strings.EqualFold(reserved, username) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPasswordClass(password string, class string) bool {
+	var matches func(rune) bool
+	switch class {
+	case PasswordLower:
+		matches = unicode.IsLower
+	case PasswordUpper:
+		matches = unicode.IsUpper
+	case PasswordDigit:
+		matches = unicode.IsDigit
+	case PasswordSymbol:
+		matches = func(char rune) bool {
+			return unicode.IsPunct(char) || unicode.IsSymbol(char)
+		}
+	default:
+		return true
+	}
+	return strings.IndexFunc(password, matches) >= 0
+}
+
+// Checks the password against the character classes, breached list and username rules
+func checkPasswordPolicy(validator *Validator, password string, username string) {
+	var missing []string
+	for _, class := range PasswordClasses {
+		if !hasPasswordClass(password, class) {
+			missing = append(missing, class)
+		}
+	}
+	if len(missing) > 0 {
+		validator.add("password", "password_classes", map[string]interface{}{"classes": strings.Join(missing, ", ")})
+	}
+	if breachedPasswords[strings.ToLower(password)] {
+		validator.add("password", "breached", nil)
+	}
+	if RejectUsernameInPassword && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		validator.add("password", "contains_username", nil)
+	}
+}
@@ -0,0 +1,126 @@
+package helpers
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"ForumDatabase/errors"
+)
+
+// Collects every rule a request breaks so they can all be reported at once
+type Validator struct {
+	err *errors.UserError
+}
+
+// Counts characters rather than bytes, so accented letters and emoji count once
+func CharacterCount(input string) int {
+	return utf8.RuneCountInString(input)
+}
+
+// Records a violation, a field breaking the same rule more than once (like several poll options) is reported once
+func (validator *Validator) add(field string, rule string, params map[string]interface{}) {
+	if validator.err == nil {
+		validator.err = errors.ErrValidation
+	}
+	for _, detail := range validator.err.Details {
+		if detail.Field == field && detail.Rule == rule {
+			return
+		}
+	}
+	validator.err = validator.err.WithDetail(field, rule, params)
+}
+
+// The collected violations, or nil when there weren't any
+func (validator *Validator) Error() *errors.UserError {
+	return validator.err
+}
+
+// Reports whether the field has already broken a rule, so checks that depend on each other can be skipped
+func (validator *Validator) Failed(field string) bool {
+	if validator.err == nil {
+		return false
+	}
+	for _, detail := range validator.err.Details {
+		if detail.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (validator *Validator) Required(field string, present bool) {
+	if !present {
+		validator.add(field, "required", nil)
+	}
+}
+
+// Checks the trimmed length of input is between min and max characters, a max of 0 means no limit
+func (validator *Validator) Length(field string, input string, min int, max int) {
+	length := CharacterCount(strings.TrimSpace(input))
+	if length < min {
+		validator.add(field, "min_length", map[string]interface{}{"min": min})
+	} else if max > 0 && length > max {
+		validator.add(field, "max_length", map[string]interface{}{"max": max})
+	}
+}
+
+// Checks the number of entries in a list is between min and max, a max of 0 means no limit
+func (validator *Validator) Count(field string, count int, min int, max int) {
+	if count < min {
+		validator.add(field, "min_count", map[string]interface{}{"min": min})
+	} else if max > 0 && count > max {
+		validator.add(field, "max_count", map[string]interface{}{"max": max})
+	}
+}
+
+func (validator *Validator) Min(field string, value int64, min int64) {
+	if value < min {
+		validator.add(field, "min_value", map[string]interface{}{"min": min})
+	}
+}
+
+func (validator *Validator) NoSpaces(field string, input string) {
+	if strings.IndexFunc(input, unicode.IsSpace) >= 0 {
+		validator.add(field, "no_spaces", nil)
+	}
+}
+
+// Checks input only uses the characters pattern allows
+func (validator *Validator) Charset(field string, input string, pattern *regexp.Regexp) {
+	if input != "" && !pattern.MatchString(input) {
+		validator.add(field, "charset", nil)
+	}
+}
+
+// Rejects control characters, except the line breaks and tabs multiline text needs
+func (validator *Validator) Printable(field string, input string, multiline bool) {
+	for _, char := range input {
+		if unicode.IsControl(char) && !(multiline && (char == '\n' || char == '\r' || char == '\t')) {
+			validator.add(field, "charset", nil)
+			return
+		}
+	}
+}
+
+func (validator *Validator) Unique(field string, unique bool) {
+	if !unique {
+		validator.add(field, "unique", nil)
+	}
+}
+
+// Adds the violations from a nested validation, such as a poll inside a thread
+func (validator *Validator) Merge(err *errors.UserError) {
+	if err == nil {
+		return
+	}
+	for _, detail := range err.Details {
+		validator.add(detail.Field, detail.Rule, detail.Params)
+	}
+}
+
+func (validator *Validator) OneOf(field string, input string, allowed []string) {
+	if !StringInSlice(allowed, input) {
+		validator.add(field, "one_of", map[string]interface{}{"allowed": strings.Join(allowed, ", ")})
+	}
+}
@@ -0,0 +1,55 @@
+package jobs
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type Job struct {
+	name string
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Runs task every interval in the background until the job is stopped
+func Every(name string, interval time.Duration, task func()) *Job {
+
+	job := &Job{name: name, stop: make(chan struct{}), done: make(chan struct{})}
+
+	go func() {
+		defer close(job.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				job.run(task)
+			case <-job.stop:
+				return
+			}
+		}
+	}()
+
+	return job
+
+}
+
+// Runs the task once, logging rather than crashing if it panics
+func (job *Job) run(task func()) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("Job %s failed: %v", job.name, recovered)
+		}
+	}()
+	task()
+}
+
+// Stops the job, waiting for a run that's in progress to finish
+func (job *Job) Stop() {
+	job.once.Do(func() {
+		close(job.stop)
+	})
+	<-job.done
+}
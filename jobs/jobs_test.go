@@ -0,0 +1,40 @@
+package jobs
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEvery(t *testing.T) {
+	var runs int32
+	job := Every("test", time.Millisecond, func() {
+		atomic.AddInt32(&runs, 1)
+	})
+	time.Sleep(time.Millisecond * 20)
+	job.Stop()
+
+	stopped := atomic.LoadInt32(&runs)
+	if stopped < 1 {
+		t.Error("Expected the job to have run")
+	}
+
+	time.Sleep(time.Millisecond * 10)
+	if atomic.LoadInt32(&runs) != stopped {
+		t.Error("Expected the job not to run after being stopped")
+	}
+}
+
+func TestEveryRecovers(t *testing.T) {
+	var runs int32
+	job := Every("panics", time.Millisecond, func() {
+		atomic.AddInt32(&runs, 1)
+		panic("failed")
+	})
+	time.Sleep(time.Millisecond * 20)
+	job.Stop()
+
+	if atomic.LoadInt32(&runs) < 2 {
+		t.Error("Expected the job to keep running after a panic")
+	}
+}
@@ -1,9 +1,76 @@
 package main
 
 import (
+	"ForumDatabase/config"
 	"ForumDatabase/router"
+	"fmt"
+	"github.com/spf13/pflag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	router.Create(false).Run()
-}
\ No newline at end of file
+
+	printConfig, err := config.ParseFlags(os.Args[1:])
+	if err == pflag.ErrHelp {
+		return
+	} else if err != nil {
+		os.Exit(2)
+	}
+
+	if printConfig {
+		configData, readErr := config.ReadConfig()
+		if readErr != nil {
+			fmt.Fprintln(os.Stderr, readErr)
+			os.Exit(1)
+		}
+		config.Print(os.Stdout, configData)
+		if validateErr := configData.Validate(); validateErr != nil {
+			fmt.Fprintln(os.Stderr, validateErr)
+			os.Exit(1)
+		}
+		return
+	}
+
+	configData, err := config.LoadConfigWithViper()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	engine, err := router.Create(false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	server := router.NewServer(configData, engine)
+	serveErr := make(chan error, 1)
+	go func() {
+		if configData.TLSCertFile != "" {
+			serveErr <- server.ListenAndServeTLS(configData.TLSCertFile, configData.TLSKeyFile)
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
+	select {
+	case err = <-serveErr:
+		// The server never came up, like when the port is taken, so there's nothing to drain
+		router.Shutdown(server, configData.ShutdownTimeout)
+		log.Fatal(err)
+	case received := <-stop:
+		log.Printf("Received %s, finishing requests in flight", received)
+	}
+
+	if err := router.Shutdown(server, configData.ShutdownTimeout); err != nil {
+		log.Fatal(err)
+	}
+	log.Print("Stopped")
+
+}
@@ -0,0 +1,190 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"ForumDatabase/helpers"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"golang.org/x/net/html"
+)
+
+var (
+	AllowImages = true
+	MaxCacheEntries = 5000
+	MentionURL = "/api/v1/users/profile/%s"
+)
+
+type cacheEntry struct {
+	revision int
+	html string
+}
+
+var (
+	converter = goldmark.New(goldmark.WithExtensions(extension.Linkify, extension.Strikethrough))
+	languageClass = regexp.MustCompile(`^language-[a-zA-Z0-9_+#-]+$`)
+	cache = make(map[string]cacheEntry)
+	cacheLock sync.Mutex
+)
+
+// Builds the allowlist used to sanitize rendered HTML
+func policy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "hr", "em", "strong", "del", "code", "pre", "blockquote", "ul", "ol", "li",
+		"h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("class").Matching(languageClass).OnElements("code")
+	p.AllowStandardURLs()
+	p.AllowAttrs("href").OnElements("a")
+	p.RequireNoFollowOnLinks(true)
+	if AllowImages {
+		p.AllowImages()
+	}
+	return p
+}
+
+// Renders CommonMark source to sanitized HTML, linking any of the mentioned usernames to their profile
+func Render(source string, mentions []string) string {
+
+	// Quote blocks split the source, with the markdown either side of them rendered on its own
+	var output bytes.Buffer
+	last := 0
+	for _, span := range helpers.QuoteSpans(source) {
+		output.WriteString(renderBlocks(source[last:span[0]]))
+		output.WriteString(fmt.Sprintf(`<blockquote class="quote" data-post-id="%s">%s</blockquote>`,
+			source[span[2]:span[3]], Render(source[span[4]:span[5]], nil)))
+		last = span[1]
+	}
+	output.WriteString(renderBlocks(source[last:]))
+	sanitized := output.String()
+
+	if len(mentions) == 0 {
+		return sanitized
+	}
+	return linkMentions(sanitized, mentions)
+}
+
+// Converts markdown without quote blocks to sanitized HTML
+func renderBlocks(source string) string {
+	if strings.TrimSpace(source) == "" {
+		return ""
+	}
+	var buffer bytes.Buffer
+	if err := converter.Convert([]byte(source), &buffer); err != nil {
+		return ""
+	}
+	return policy().Sanitize(buffer.String())
+}
+
+// Finds the link targets the source renders to, including the bare www. hosts and email addresses Linkify turns into links
+func Links(source string) []string {
+	var links []string
+	tokenizer := html.NewTokenizer(strings.NewReader(Render(source, nil)))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return links
+		}
+		if tokenType != html.StartTagToken {
+			continue
+		}
+		if token := tokenizer.Token(); token.Data == "a" {
+			for _, attribute := range token.Attr {
+				if attribute.Key == "href" {
+					links = append(links, attribute.Val)
+				}
+			}
+		}
+	}
+}
+
+// Wraps @username text in profile links, skipping anything inside code or existing links
+func linkMentions(sanitized string, mentions []string) string {
+
+	known := make(map[string]bool)
+	for _, username := range mentions {
+		known[strings.ToLower(username)] = true
+	}
+
+	var output bytes.Buffer
+	skipDepth := 0
+	tokenizer := html.NewTokenizer(strings.NewReader(sanitized))
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+		skipped := token.Data == "a" || token.Data == "code" || token.Data == "pre"
+
+		switch {
+		case tokenType == html.StartTagToken && skipped:
+			skipDepth++
+		case tokenType == html.EndTagToken && skipped && skipDepth > 0:
+			skipDepth--
+		case tokenType == html.TextToken && skipDepth == 0:
+			raw = linkMentionsInText(raw, known)
+		}
+
+		output.WriteString(raw)
+	}
+
+	return output.String()
+}
+
+// Links the mentions found the same way helpers.ParseMentions finds them, so a link always matches a recorded mention
+func linkMentionsInText(text string, known map[string]bool) string {
+	var output bytes.Buffer
+	last := 0
+	for _, span := range helpers.MentionSpans(text) {
+		match := text[span[0]:span[1]]
+		username := html.UnescapeString(match[1:])
+		if !known[strings.ToLower(username)] {
+			continue
+		}
+		link := fmt.Sprintf(MentionURL, url.PathEscape(username))
+		output.WriteString(text[last:span[0]])
+		output.WriteString(fmt.Sprintf(`<a href="%s" class="mention">%s</a>`, html.EscapeString(link), match))
+		last = span[1]
+	}
+	output.WriteString(text[last:])
+	return output.String()
+}
+
+// Returns the cached output for the key if it was rendered at this revision
+func Lookup(key string, revision int) (string, bool) {
+	cacheLock.Lock()
+	entry, exists := cache[key]
+	cacheLock.Unlock()
+
+	if exists && entry.revision == revision {
+		return entry.html, true
+	}
+	return "", false
+}
+
+// Returns the cached output for the key at this revision, otherwise calls render and caches the result
+func Cached(key string, revision int, render func() string) string {
+	if html, cached := Lookup(key, revision); cached {
+		return html
+	}
+
+	rendered := render()
+
+	cacheLock.Lock()
+	if len(cache) >= MaxCacheEntries {
+		cache = make(map[string]cacheEntry)
+	}
+	cache[key] = cacheEntry{revision, rendered}
+	cacheLock.Unlock()
+
+	return rendered
+}
@@ -0,0 +1,96 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderCodeBlock(t *testing.T) {
+	html := Render("```go\nfmt.Println(\"hi\")\n```", nil)
+	if !strings.Contains(html, `<code class="language-go">`) {
+		t.Error("Expected code block to keep its language class: ", html)
+	}
+}
+
+func TestRenderAutolink(t *testing.T) {
+	html := Render("Have a look at https://example.com for more", nil)
+	if !strings.Contains(html, `href="https://example.com"`) || !strings.Contains(html, `rel="nofollow"`) {
+		t.Error("Expected autolink with rel=nofollow: ", html)
+	}
+}
+
+func TestRenderSanitize(t *testing.T) {
+	html := Render("<script>alert(1)</script> [click](javascript:alert(1))", nil)
+	if strings.Contains(html, "<script") || strings.Contains(html, "javascript:") {
+		t.Error("Expected unsafe markup to be removed: ", html)
+	}
+}
+
+func TestRenderImages(t *testing.T) {
+	if html := Render("![cat](https://example.com/cat.png)", nil); !strings.Contains(html, "<img") {
+		t.Error("Expected image to be rendered: ", html)
+	}
+
+	AllowImages = false
+	defer func() { AllowImages = true }()
+
+	if html := Render("![cat](https://example.com/cat.png)", nil); strings.Contains(html, "<img") {
+		t.Error("Expected image to be removed when images are disabled: ", html)
+	}
+}
+
+func TestRenderMentions(t *testing.T) {
+	html := Render("Thanks @goldtime34, but `@goldtime34` and @nobody aren't links", []string{"GoldTime34"})
+	if strings.Count(html, `class="mention"`) != 1 || !strings.Contains(html, `href="/api/v1/users/profile/goldtime34"`) {
+		t.Error("Expected exactly one mention link: ", html)
+	}
+}
+
+func TestRenderMentionPunctuation(t *testing.T) {
+	html := Render("Ask @bob. Or write to mail@bob instead", []string{"bob"})
+	if strings.Count(html, `class="mention"`) != 1 || !strings.Contains(html, `class="mention">@bob</a>.`) {
+		t.Error("Expected the trailing full stop to be left out of the link and the email address not to be linked: ", html)
+	}
+}
+
+func TestRenderQuotes(t *testing.T) {
+	html := Render("[quote=12]Some **quoted** text[/quote]\n\nAnd my reply", nil)
+	if !strings.Contains(html, `<blockquote class="quote" data-post-id="12"><p>Some <strong>quoted</strong> text</p>`) {
+		t.Error("Expected quote block referencing the source post: ", html)
+	}
+	if !strings.Contains(html, "<p>And my reply</p>") {
+		t.Error("Expected reply text after quote: ", html)
+	}
+}
+
+func TestRenderQuotesLeaveCodeAlone(t *testing.T) {
+	html := Render("```\n[quote=1]x[/quote]\n```", nil)
+	if strings.Contains(html, "blockquote") || !strings.Contains(html, "[quote=1]x[/quote]") {
+		t.Error("Expected a quote inside a code fence to stay as code: ", html)
+	}
+
+	html = Render("QUOTEBLOCKPLACEHOLDER0 [quote=5]quoted[/quote]", nil)
+	if strings.Count(html, "blockquote class") != 1 || !strings.Contains(html, "<p>QUOTEBLOCKPLACEHOLDER0</p>") {
+		t.Error("Expected text in the post never to be replaced by a quote: ", html)
+	}
+}
+
+func TestCached(t *testing.T) {
+	first := Cached("thread:1", 1, func() string { return Render("**first**", nil) })
+	second := Cached("thread:1", 1, func() string { return Render("**changed**", nil) })
+	if first != second {
+		t.Error("Expected cached output for the same revision")
+	}
+
+	third := Cached("thread:1", 2, func() string { return Render("**changed**", nil) })
+	if !strings.Contains(third, "changed") {
+		t.Error("Expected a new revision to be rendered again: ", third)
+	}
+
+	if html, cached := Lookup("thread:1", 2); !cached || html != third {
+		t.Error("Expected the latest revision to be found in the cache")
+	}
+	if _, cached := Lookup("thread:1", 1); cached {
+		t.Error("Expected an older revision not to be found in the cache")
+	}
+}
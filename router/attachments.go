@@ -0,0 +1,101 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/blobstore"
+	"ForumDatabase/config"
+	"ForumDatabase/database"
+	"ForumDatabase/errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+type AttachmentRequest struct {
+	ThreadId uint `form:"threadId"`
+	PostId uint `form:"postId"`
+}
+
+var blobs blobstore.BlobStore
+
+// Creates the blob store selected in the config
+func createBlobStore(configData *config.ConfigData) (blobstore.BlobStore, error) {
+	if configData.AttachmentStore == "s3" {
+		return blobstore.NewS3Store(configData.S3Endpoint, configData.S3Region, configData.S3Bucket,
+			configData.S3AccessKey, configData.S3SecretKey), nil
+	}
+	return blobstore.NewLocalStore(configData.AttachmentDir)
+}
+
+func uploadAttachment(context *gin.Context) {
+
+	data := new (AttachmentRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	fileHeader, fileErr := context.FormFile("file")
+	if fileErr != nil {
+		renderError(context, errors.ErrInvalidRequest.WithDetail("file", "required", nil))
+		return
+	}
+
+	if fileHeader.Size > database.MaxAttachmentSize {
+		renderError(context, errors.ErrTooLarge)
+		return
+	}
+
+	file, openErr := fileHeader.Open()
+	if openErr != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+	defer file.Close()
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	attachment, createErr := database.CreateAttachment(db, blobs, user, data.ThreadId, data.PostId, fileHeader.Filename, file)
+	if createErr != nil {
+		renderError(context, createErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": attachment,
+	})
+
+}
+
+func downloadAttachment(context *gin.Context) {
+
+	attachmentId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	attachment, findErr := database.FindAttachment(db, optionalUser(context), uint(attachmentId))
+	if findErr != nil {
+		renderError(context, findErr)
+		return
+	}
+
+	reader, readErr := blobs.Get(attachment.Key)
+	if readErr != nil {
+		renderError(context, errors.ErrSystem)
+		return
+	}
+	defer reader.Close()
+
+	context.Header("Content-Type", attachment.MimeType)
+	context.Header("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	context.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	context.Header("X-Content-Type-Options", "nosniff")
+	context.Status(http.StatusOK)
+	io.Copy(context.Writer, reader)
+
+}
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/database"
+	"ForumDatabase/errors"
+	"ForumDatabase/helpers"
+	"net/http"
+	"strconv"
+)
+
+type BookmarkRequest struct {
+	TargetType string `json:"targetType"`
+	TargetId uint `json:"targetId"`
+	Note string `json:"note"`
+	Folder string `json:"folder"`
+}
+
+type BookmarkUpdateRequest struct {
+	Note string `json:"note"`
+	Folder string `json:"folder"`
+}
+
+type BookmarksQueryRequest struct {
+	QueryRequest
+	Folder string `form:"folder"`
+}
+
+func (request *BookmarkRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.OneOf("targetType", request.TargetType, []string{database.TargetThread, database.TargetPost})
+	validator.Required("targetId", request.TargetId > 0)
+	validator.Merge((&BookmarkUpdateRequest{Note: request.Note, Folder: request.Folder}).Validate())
+	return validator.Error()
+}
+
+func (request *BookmarkUpdateRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Length("note", request.Note, 0, helpers.MaxLengthShortText)
+	validator.Printable("note", request.Note, true)
+	validator.Length("folder", request.Folder, 0, helpers.MaxLengthShortText)
+	validator.Printable("folder", request.Folder, false)
+	return validator.Error()
+}
+
+func addBookmark(context *gin.Context) {
+
+	data := new (BookmarkRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	bookmark, addErr := database.AddBookmark(db, user, data.TargetType, data.TargetId, data.Note, data.Folder)
+	if addErr != nil {
+		renderError(context, addErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": bookmark,
+	})
+
+}
+
+func updateBookmark(context *gin.Context) {
+
+	data := new (BookmarkUpdateRequest)
+	bookmarkId, convertErr := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if convertErr != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if updateErr := database.UpdateBookmark(db, user, uint(bookmarkId), data.Note, data.Folder); updateErr != nil {
+		renderError(context, updateErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
+
+func deleteBookmark(context *gin.Context) {
+
+	bookmarkId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if deleteErr := database.DeleteBookmark(db, user, uint(bookmarkId)); deleteErr != nil {
+		renderError(context, deleteErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
+
+func readBookmarks(context *gin.Context) {
+
+	data := new (BookmarksQueryRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	var bookmarks []database.Bookmark
+	database.GetBookmarks(db, user, data.Folder, data.Timestamp, data.Limit, &bookmarks)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": bookmarks,
+	})
+
+}
+
+func exportUserData(context *gin.Context) {
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": database.ExportUserData(db, user),
+	})
+
+}
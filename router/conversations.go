@@ -0,0 +1,189 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/database"
+	"ForumDatabase/errors"
+	"ForumDatabase/helpers"
+	"net/http"
+	"strconv"
+)
+
+type ConversationRequest struct {
+	Participants []uint `json:"participants"`
+	Content string `json:"content"`
+}
+
+type MessageRequest struct {
+	Content string `json:"content"`
+}
+
+func (request *ConversationRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Count("participants", len(request.Participants), 1, 0)
+	validator.Merge(helpers.ValidateContent(request.Content))
+	return validator.Error()
+}
+
+func (request *MessageRequest) Validate() *errors.UserError {
+	return helpers.ValidateContent(request.Content)
+}
+
+func startConversation(context *gin.Context) {
+
+	data := new (ConversationRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	conversation, startErr := database.StartConversation(db, user, data.Participants, data.Content)
+	if startErr != nil {
+		renderError(context, startErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": conversation,
+	})
+
+}
+
+func readConversations(context *gin.Context) {
+
+	data := new (QueryRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	var conversations []database.Conversation
+	database.GetConversations(db, user, data.Timestamp, data.Limit, &conversations)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": conversations,
+	})
+
+}
+
+func readMessages(context *gin.Context) {
+
+	conversationId, convertErr := strconv.ParseUint(context.Param("id"), 10, 64)
+	data := new (QueryRequest)
+
+	if convertErr != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	var messages []database.Message
+	if readErr := database.GetMessages(db, user, uint(conversationId), data.Timestamp, data.Limit, &messages); readErr != nil {
+		renderError(context, readErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": messages,
+	})
+
+}
+
+func sendMessage(context *gin.Context) {
+
+	data := new (MessageRequest)
+	conversationId, convertErr := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if convertErr != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	message, sendErr := database.SendMessage(db, user, uint(conversationId), data.Content)
+	if sendErr != nil {
+		renderError(context, sendErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": message,
+	})
+
+}
+
+func readConversation(context *gin.Context) {
+
+	conversationId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if readErr := database.MarkConversationRead(db, user, uint(conversationId)); readErr != nil {
+		renderError(context, readErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
+
+func leaveConversation(context *gin.Context) {
+
+	conversationId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if leaveErr := database.LeaveConversation(db, user, uint(conversationId)); leaveErr != nil {
+		renderError(context, leaveErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
+
+func readUnreadMessages(context *gin.Context) {
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": database.CountUnreadMessages(db, user),
+	})
+
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/database"
+	"ForumDatabase/errors"
+	"ForumDatabase/helpers"
+	"net/http"
+	"strconv"
+)
+
+type DraftRequest struct {
+	ThreadId uint `json:"threadId"`
+	Title string `json:"title"`
+	Content string `json:"content"`
+}
+
+// Drafts are unfinished so only the maximum lengths apply
+func (request *DraftRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Length("title", request.Title, 0, helpers.MaxLengthTitle)
+	validator.Length("content", request.Content, 0, helpers.MaxLengthContent)
+	return validator.Error()
+}
+
+func saveDraft(context *gin.Context) {
+
+	data := new (DraftRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	draft, saveErr := database.SaveDraft(db, user, data.ThreadId, data.Title, data.Content)
+	if saveErr != nil {
+		renderError(context, saveErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": draft,
+	})
+
+}
+
+func readDrafts(context *gin.Context) {
+
+	data := new (QueryRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	var drafts []database.Draft
+	database.GetDrafts(db, user, data.Timestamp, data.Limit, &drafts)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": drafts,
+	})
+
+}
+
+// The id is the thread the draft replies to, 0 for a new thread draft
+func readDraft(context *gin.Context) {
+
+	threadId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	draft, findErr := database.FindDraft(db, user, uint(threadId))
+	if findErr != nil {
+		renderError(context, findErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": draft,
+	})
+
+}
+
+func deleteDraft(context *gin.Context) {
+
+	threadId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if deleteErr := database.DeleteDraft(db, user, uint(threadId)); deleteErr != nil {
+		renderError(context, deleteErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	stdcontext "context"
+	"ForumDatabase/config"
+	"ForumDatabase/database"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const healthOK = "ok"
+
+// Set once shutdown starts so /readyz sends new traffic elsewhere while the requests in flight finish
+var draining int32
+
+// Set once the schema has checked out, tables don't go missing from a running forum so probes after that only ping
+var schemaChecked int32
+
+// Checks the databases can be reached and have been migrated, reporting each check by name
+func healthChecks(ctx stdcontext.Context) (map[string]string, bool) {
+	checks := map[string]string{"database": healthOK, "migrations": healthOK}
+	healthy := true
+	if readDB != db {
+		checks["replica"] = healthOK
+		if err := database.Ping(ctx, readDB); err != nil {
+			checks["replica"] = err.Error()
+			healthy = false
+		}
+	}
+	if err := database.Ping(ctx, db); err != nil {
+		checks["database"] = err.Error()
+		checks["migrations"] = "unknown, the database can't be reached"
+		return checks, false
+	}
+	if atomic.LoadInt32(&schemaChecked) == 0 {
+		if err := database.CheckSchema(db); err != nil {
+			checks["migrations"] = err.Error()
+			return checks, false
+		}
+		atomic.StoreInt32(&schemaChecked, 1)
+	}
+	return checks, healthy
+}
+
+func renderHealth(context *gin.Context, checks map[string]string, healthy bool) {
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+	context.JSON(status, gin.H{
+		"status": status,
+		"data": checks,
+	})
+}
+
+func checkHealth(context *gin.Context) {
+	checks, healthy := healthChecks(context.Request.Context())
+	renderHealth(context, checks, healthy)
+}
+
+// Like /healthz, but also fails while shutting down
+func checkReadiness(context *gin.Context) {
+	checks, healthy := healthChecks(context.Request.Context())
+	if atomic.LoadInt32(&draining) == 1 {
+		checks["server"] = "shutting down"
+		healthy = false
+	} else {
+		checks["server"] = healthOK
+	}
+	renderHealth(context, checks, healthy)
+}
+
+// Wraps the router in a server with the timeouts from the config
+func NewServer(configData *config.ConfigData, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr: fmt.Sprintf("%s:%d", configData.Host, configData.Port),
+		Handler: handler,
+		ReadTimeout: configData.HTTPReadTimeout,
+		ReadHeaderTimeout: configData.HTTPReadHeaderTimeout,
+		WriteTimeout: configData.HTTPWriteTimeout,
+		IdleTimeout: configData.HTTPIdleTimeout,
+	}
+}
+
+// Stops taking requests, waits up to timeout for those in flight to finish, then stops the jobs and closes the database
+func Shutdown(server *http.Server, timeout time.Duration) error {
+	atomic.StoreInt32(&draining, 1)
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	StopJobs()
+	if readDB != db {
+		readDB.Close()
+	}
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/helpers"
+	"net/http"
+)
+
+func readRules(context *gin.Context) {
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": helpers.Rules(),
+	})
+
+}
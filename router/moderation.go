@@ -0,0 +1,380 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/database"
+	"ForumDatabase/errors"
+	"ForumDatabase/helpers"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type RoleRequest struct {
+	Role int `json:"role"`
+}
+
+type FlagRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (request *FlagRequest) Validate() *errors.UserError {
+	return helpers.ValidateShortText("reason", request.Reason)
+}
+
+type TrustRequest struct {
+	Level *int `json:"level"`
+}
+
+// DurationHours of 0 bans permanently
+type BanRequest struct {
+	Kind string `json:"kind"`
+	UserID uint `json:"userId"`
+	Value string `json:"value"`
+	Reason string `json:"reason"`
+	DurationHours int `json:"durationHours"`
+}
+
+func (request *BanRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.OneOf("kind", request.Kind, []string{database.BanUser, database.BanIP, database.BanUsername})
+	validator.Length("reason", request.Reason, 0, helpers.MaxLengthShortText)
+	validator.Length("value", request.Value, 0, helpers.MaxLengthShortText)
+	validator.Min("durationHours", int64(request.DurationHours), 0)
+	return validator.Error()
+}
+
+type AuditQueryRequest struct {
+	QueryRequest
+	ActorID uint `form:"actorId"`
+	Action string `form:"action"`
+	TargetType string `form:"targetType"`
+	TargetID uint `form:"targetId"`
+}
+
+type BansQueryRequest struct {
+	QueryRequest
+	Active bool `form:"active"`
+}
+
+// Only lets through users with at least the moderator role, must come after authMiddleware
+func moderatorMiddleware() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		user := context.MustGet("user").(*database.User)
+		if !user.IsModerator() {
+			renderError(context, errors.ErrForbidden)
+			return
+		}
+		context.Next()
+	}
+}
+
+// Only lets through users with the admin role, must come after authMiddleware
+func adminMiddleware() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		user := context.MustGet("user").(*database.User)
+		if !user.IsAdmin() {
+			renderError(context, errors.ErrForbidden)
+			return
+		}
+		context.Next()
+	}
+}
+
+func readHeldContent(context *gin.Context) {
+
+	data := new (QueryRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	var threads []database.Thread
+	var posts []database.Post
+	database.GetHeldContent(db, data.Timestamp, data.Limit, &threads, &posts)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"threads": threads,
+		"posts": posts,
+	})
+
+}
+
+// Creates a handler that approves or rejects the held thread or post with the id param
+func moderate(targetType string, approve bool) gin.HandlerFunc {
+	return func(context *gin.Context) {
+
+		targetId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+		if err != nil {
+			renderError(context, errors.ErrInvalidRequest)
+			return
+		}
+
+		value := context.MustGet("user")
+		user := value.(*database.User)
+
+		var moderateErr *errors.UserError
+		switch {
+		case targetType == database.TargetThread && approve:
+			moderateErr = database.ApproveThread(auditDB(context), user, uint(targetId))
+		case targetType == database.TargetThread:
+			moderateErr = database.RejectThread(auditDB(context), user, uint(targetId))
+		case approve:
+			moderateErr = database.ApprovePost(auditDB(context), user, uint(targetId))
+		default:
+			moderateErr = database.RejectPost(auditDB(context), user, uint(targetId))
+		}
+
+		if moderateErr != nil {
+			renderError(context, moderateErr)
+			return
+		}
+
+		context.JSON(http.StatusOK, gin.H {
+			"status": http.StatusOK,
+		})
+
+	}
+}
+
+func setUserRole(context *gin.Context) {
+
+	targetId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	data := new (RoleRequest)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if roleErr := database.SetUserRole(auditDB(context), user, uint(targetId), data.Role); roleErr != nil {
+		renderError(context, roleErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
+
+func flagContent(targetType string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+
+		data := new (FlagRequest)
+		targetId, convertErr := strconv.ParseUint(context.Param("id"), 10, 64)
+
+		if convertErr != nil {
+			renderError(context, errors.ErrInvalidRequest)
+			return
+		}
+		if !bindRequest(context, data) {
+			return
+		}
+
+		value := context.MustGet("user")
+		user := value.(*database.User)
+
+		if flagErr := database.FlagContent(db, user, targetType, uint(targetId), data.Reason); flagErr != nil {
+			renderError(context, flagErr)
+			return
+		}
+
+		context.JSON(http.StatusOK, gin.H {
+			"status": http.StatusOK,
+		})
+
+	}
+}
+
+func readFlags(context *gin.Context) {
+
+	data := new (QueryRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	var flags []database.Flag
+	database.GetFlags(db, data.Timestamp, data.Limit, &flags)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": flags,
+	})
+
+}
+
+func readTrustLevel(context *gin.Context) {
+
+	targetId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	target, findErr := database.FindUser(db, uint(targetId))
+	if findErr != nil {
+		renderError(context, findErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": database.GetTrustProgress(db, target),
+	})
+
+}
+
+func setTrustLevel(context *gin.Context) {
+
+	targetId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	data := new (TrustRequest)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if trustErr := database.SetTrustLevelOverride(auditDB(context), user, uint(targetId), data.Level); trustErr != nil {
+		renderError(context, trustErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
+
+func issueBan(context *gin.Context) {
+
+	data := new (BanRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	duration := time.Hour * time.Duration(data.DurationHours)
+	ban, banErr := database.IssueBan(auditDB(context), user, data.Kind, data.UserID, data.Value, data.Reason, duration)
+	if banErr != nil {
+		renderError(context, banErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": ban,
+	})
+
+}
+
+func readBans(context *gin.Context) {
+
+	data := new (BansQueryRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	var bans []database.Ban
+	database.GetBans(db, data.Timestamp, data.Limit, data.Active, &bans)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": bans,
+	})
+
+}
+
+func liftBan(context *gin.Context) {
+
+	banId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if liftErr := database.LiftBan(auditDB(context), user, uint(banId)); liftErr != nil {
+		renderError(context, liftErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
+
+func shadowBan(shadowBanned bool) gin.HandlerFunc {
+	return func(context *gin.Context) {
+
+		targetId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+		if err != nil {
+			renderError(context, errors.ErrInvalidRequest)
+			return
+		}
+
+		value := context.MustGet("user")
+		user := value.(*database.User)
+
+		if banErr := database.SetShadowBanned(auditDB(context), user, uint(targetId), shadowBanned); banErr != nil {
+			renderError(context, banErr)
+			return
+		}
+
+		context.JSON(http.StatusOK, gin.H {
+			"status": http.StatusOK,
+		})
+
+	}
+}
+
+func readAuditLog(context *gin.Context) {
+
+	data := new (AuditQueryRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	query := database.AuditQuery{ActorID: data.ActorID, Action: data.Action, TargetType: data.TargetType, TargetID: data.TargetID,
+		Timestamp: data.Timestamp, Limit: data.Limit}
+	var entries []database.AuditEntry
+	database.GetAuditLog(db, query, &entries)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": entries,
+	})
+
+}
+
+func reconcileCounters(context *gin.Context) {
+
+	drifts, err := database.ReconcileCounters(db)
+	if err != nil {
+		renderError(context, errors.ErrSystem)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": drifts,
+	})
+
+}
@@ -0,0 +1,53 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/database"
+	"ForumDatabase/errors"
+	"net/http"
+	"strconv"
+)
+
+func readNotifications(context *gin.Context) {
+
+	data := new (QueryRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	var notifications []database.Notification
+	database.GetNotifications(db, user, data.Timestamp, data.Limit, &notifications)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": notifications,
+		"unread": database.CountUnreadNotifications(db, user),
+	})
+
+}
+
+func readNotification(context *gin.Context) {
+
+	notificationId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if readErr := database.MarkNotificationRead(db, user, uint(notificationId)); readErr != nil {
+		renderError(context, readErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
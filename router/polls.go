@@ -0,0 +1,70 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/database"
+	"ForumDatabase/errors"
+	"ForumDatabase/helpers"
+	"net/http"
+	"strconv"
+)
+
+type VoteRequest struct {
+	Options []uint `json:"options"`
+}
+
+func (request *VoteRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Count("options", len(request.Options), 1, helpers.MaxPollOptions)
+	return validator.Error()
+}
+
+func readThread(context *gin.Context) {
+
+	threadId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	user := optionalUser(context)
+	thread, readErr := database.GetThreadDetail(readerFor(user), user, uint(threadId))
+	if readErr != nil {
+		renderError(context, readErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": thread,
+	})
+
+}
+
+func voteInPoll(context *gin.Context) {
+
+	data := new (VoteRequest)
+	threadId, convertErr := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if convertErr != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if voteErr := database.VoteInPoll(db, user, uint(threadId), data.Options); voteErr != nil {
+		renderError(context, voteErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
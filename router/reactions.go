@@ -0,0 +1,109 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/database"
+	"ForumDatabase/errors"
+	"ForumDatabase/helpers"
+	"net/http"
+	"strconv"
+)
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+func (request *ReactionRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.OneOf("emoji", request.Emoji, helpers.AllowedReactions)
+	return validator.Error()
+}
+
+type ReactionsQueryRequest struct {
+	QueryRequest
+	Emoji string `form:"emoji"`
+}
+
+func addReaction(targetType string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+
+		data := new (ReactionRequest)
+		targetId, convertErr := strconv.ParseUint(context.Param("id"), 10, 64)
+
+		if convertErr != nil {
+			renderError(context, errors.ErrInvalidRequest)
+			return
+		}
+		if !bindRequest(context, data) {
+			return
+		}
+
+		value := context.MustGet("user")
+		user := value.(*database.User)
+
+		if reactErr := database.AddReaction(db, user, targetType, uint(targetId), data.Emoji); reactErr != nil {
+			renderError(context, reactErr)
+			return
+		}
+
+		context.JSON(http.StatusOK, gin.H {
+			"status": http.StatusOK,
+		})
+
+	}
+}
+
+func removeReaction(targetType string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+
+		data := new (ReactionRequest)
+		targetId, convertErr := strconv.ParseUint(context.Param("id"), 10, 64)
+
+		if convertErr != nil {
+			renderError(context, errors.ErrInvalidRequest)
+			return
+		}
+		if !bindRequest(context, data) {
+			return
+		}
+
+		value := context.MustGet("user")
+		user := value.(*database.User)
+
+		if removeErr := database.RemoveReaction(db, user, targetType, uint(targetId), data.Emoji); removeErr != nil {
+			renderError(context, removeErr)
+			return
+		}
+
+		context.JSON(http.StatusOK, gin.H {
+			"status": http.StatusOK,
+		})
+
+	}
+}
+
+func readReactions(targetType string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+
+		targetId, convertErr := strconv.ParseUint(context.Param("id"), 10, 64)
+		data := new (ReactionsQueryRequest)
+
+		if convertErr != nil {
+			renderError(context, errors.ErrInvalidRequest)
+			return
+		}
+		if !bindRequest(context, data) {
+			return
+		}
+
+		var reactions []database.Reaction
+		user := optionalUser(context)
+		database.GetReactions(readerFor(user), user, targetType, uint(targetId), data.Emoji, data.Timestamp, data.Limit, &reactions)
+
+		context.JSON(http.StatusOK, gin.H{
+			"status": http.StatusOK,
+			"data": reactions,
+		})
+
+	}
+}
@@ -0,0 +1,45 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/database"
+	"ForumDatabase/errors"
+	"net/http"
+	"strconv"
+)
+
+func markThreadRead(context *gin.Context) {
+
+	threadId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+
+	if err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	if readErr := database.MarkWholeThreadRead(db, user, uint(threadId)); readErr != nil {
+		renderError(context, readErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
+
+func markAllRead(context *gin.Context) {
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	database.MarkAllRead(db, user)
+
+	context.JSON(http.StatusOK, gin.H {
+		"status": http.StatusOK,
+	})
+
+}
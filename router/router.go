@@ -1,49 +1,165 @@
 package router
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"ForumDatabase/database"
 	"github.com/jinzhu/gorm"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"github.com/gin-contrib/sessions"
 	"ForumDatabase/config"
 	"ForumDatabase/errors"
+	"ForumDatabase/markdown"
+	"ForumDatabase/helpers"
+	"ForumDatabase/jobs"
+	"ForumDatabase/spam"
+	"sync/atomic"
+	"time"
 )
 
+// Requests that check their own fields once they're bound, see bindRequest
+type validatable interface {
+	Validate() *errors.UserError
+}
+
 type AuthRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Registering applies the username and password rules, logging in only needs both to be there
+// so accounts made under older rules can still log in
+type RegisterRequest struct {
+	AuthRequest
 }
 
 type QueryRequest struct {
-	Timestamp int64 `form:"timestamp" binding:"required"`
-	Limit int `form:"limit" binding:"required"`
+	Timestamp int64 `form:"timestamp"`
+	Limit int `form:"limit"`
+}
+
+type ThreadsQueryRequest struct {
+	Timestamp int64 `form:"timestamp"`
+	Limit int `form:"limit"`
+	Sort string `form:"sort"`
+	Window string `form:"window"`
+	Cursor string `form:"cursor"`
+}
+
+type ThreadRequest struct {
+	Title string `json:"title"`
+	Content string `json:"content"`
+	Poll *database.NewPoll `json:"poll"`
+}
+
+type PostRequest struct {
+	Content string `json:"content"`
+	ParentPostID uint `json:"parentPostId"`
+}
+
+type SearchRequest struct {
+	QueryRequest
+	Query string `form:"q"`
+}
+
+type PostsQueryRequest struct {
+	QueryRequest
+	Mode string `form:"mode"`
+	Depth int `form:"depth"`
+	ParentId uint `form:"parentId"`
+}
+
+func (request *AuthRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Required("username", request.Username != "")
+	validator.Required("password", request.Password != "")
+	return validator.Error()
+}
+
+func (request *RegisterRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Merge(helpers.ValidateUsername(request.Username))
+	validator.Merge(helpers.ValidatePassword(request.Password, request.Username))
+	return validator.Error()
+}
+
+func (request *QueryRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Required("timestamp", request.Timestamp != 0)
+	validator.Min("limit", int64(request.Limit), 1)
+	return validator.Error()
+}
+
+func (request *ThreadsQueryRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Min("limit", int64(request.Limit), 1)
+	return validator.Error()
+}
+
+func (request *ThreadRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Merge(helpers.ValidateTitle(request.Title))
+	validator.Merge(helpers.ValidateContent(request.Content))
+	if request.Poll != nil {
+		validator.Merge(database.ValidatePoll(request.Poll))
+	}
+	return validator.Error()
+}
+
+func (request *PostRequest) Validate() *errors.UserError {
+	return helpers.ValidateContent(request.Content)
+}
+
+func (request *SearchRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Merge(request.QueryRequest.Validate())
+	validator.Required("q", strings.TrimSpace(request.Query) != "")
+	validator.Length("q", request.Query, 0, helpers.MaxLengthShortText)
+	return validator.Error()
+}
+
+func (request *PostsQueryRequest) Validate() *errors.UserError {
+	validator := new (helpers.Validator)
+	validator.Merge(request.QueryRequest.Validate())
+	validator.Min("depth", int64(request.Depth), 0)
+	return validator.Error()
 }
 
 var db *gorm.DB
+// The read replica when one is configured, otherwise the same connection as db
+var readDB *gorm.DB
+var backgroundJobs []*jobs.Job
 
 func readLatestThreads(context *gin.Context) {
 
-	data := new (QueryRequest)
-	if bindErr := context.Bind(data); bindErr != nil {
-		context.AbortWithStatus(http.StatusBadRequest)
+	data := new (ThreadsQueryRequest)
+	if !bindRequest(context, data) {
 		return
 	}
 
-	userValue, exists := context.Get("user")
+	user := optionalUser(context)
 	var threads []database.Thread
 
-	if exists {
-		user := userValue.(*database.User)
-		database.GetLatestThreadsForUser(db, user, data.Timestamp, data.Limit, &threads)
-	} else {
-		database.GetLatestThreads(db, data.Timestamp, data.Limit, &threads)
+	query := database.ThreadQuery{Sort: data.Sort, Window: data.Window, Cursor: data.Cursor, Timestamp: data.Timestamp, Limit: data.Limit}
+	cursor, queryErr := database.GetThreads(readerFor(user), user, query, &threads)
+	if queryErr != nil {
+		renderError(context, queryErr)
+		return
+	}
+
+	if user != nil {
+		database.LoadUnreadCounts(db, user, threads)
 	}
+	database.LoadThreadReactions(readerFor(user), user, threads)
 
 	context.JSON(http.StatusOK, gin.H{
 		"status": http.StatusOK,
 		"data": threads,
+		"cursor": cursor,
 	})
 
 }
@@ -51,15 +167,29 @@ func readLatestThreads(context *gin.Context) {
 func readLatestPosts(context *gin.Context) {
 
 	threadId, threadIdErr := strconv.ParseUint(context.Param("id"), 10, 64)
-	data := new (QueryRequest)
+	data := new (PostsQueryRequest)
 
-	if bindErr := context.Bind(data); threadIdErr != nil || bindErr != nil {
-		context.AbortWithStatus(http.StatusBadRequest)
+	if threadIdErr != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+	if !bindRequest(context, data) {
 		return
 	}
 
+	user := optionalUser(context)
 	var posts []database.Post
-	database.GetPostsForThread(db, data.Timestamp, data.Limit, uint(threadId), &posts)
+	reader := readerFor(user)
+	if data.Mode == "tree" {
+		database.GetPostTree(reader, user, data.Timestamp, data.Limit, uint(threadId), data.ParentId, data.Depth, &posts)
+	} else {
+		database.GetPostsForThreadForUser(reader, user, data.Timestamp, data.Limit, uint(threadId), &posts)
+	}
+	database.LoadPostReactions(reader, user, posts)
+	database.LoadPostAttachments(reader, posts)
+	if user != nil {
+		database.MarkPostsRead(db, user, uint(threadId), posts)
+	}
 
 	context.JSON(http.StatusOK, gin.H{
 		"status": http.StatusOK,
@@ -71,19 +201,15 @@ func login(context *gin.Context) {
 
 	session := sessions.Default(context)
 	data := new(AuthRequest)
-	err := context.BindJSON(data)
-
-	if err != nil {
-		context.AbortWithError(http.StatusBadRequest, err)
+	if !bindRequest(context, data) {
 		return
 	}
 
 	user, userErr := database.FindUserByCredentials(db, data.Username, data.Password)
 	if userErr != nil {
-		context.JSON(http.StatusOK, gin.H {
-			"status": http.StatusOK,
-			"error": userErr.Error(),
-		})
+		renderError(context, userErr)
+	} else if ban := database.FindActiveBan(db, user); ban != nil {
+		renderBan(context, ban)
 	} else {
 		session.Set("user_id", user.UniqueID)
 		session.Save()
@@ -96,11 +222,13 @@ func login(context *gin.Context) {
 
 func register(context *gin.Context) {
 
-	data := new(AuthRequest)
-	err := context.BindJSON(data)
+	data := new(RegisterRequest)
+	if !bindRequest(context, data) {
+		return
+	}
 
-	if err != nil {
-		context.AbortWithError(http.StatusBadRequest, err)
+	if banErr := database.CheckRegistrationBans(db, data.Username, context.ClientIP()); banErr != nil {
+		renderError(context, banErr)
 		return
 	}
 
@@ -121,13 +249,18 @@ func authMiddleware() gin.HandlerFunc {
 		session := sessions.Default(context)
 		userID := session.Get("user_id")
 		if userID == nil {
-			context.AbortWithStatus(http.StatusUnauthorized)
+			renderError(context, errors.ErrUnauthorized)
 			return
 		}
 
 		user, err := database.FindUserByUnique(db, userID.(string))
 		if err != nil {
-			context.AbortWithStatus(http.StatusUnauthorized)
+			renderError(context, errors.ErrUnauthorized)
+			return
+		}
+
+		if ban := database.FindActiveBan(db, user); ban != nil {
+			renderBan(context, ban)
 			return
 		}
 
@@ -144,31 +277,51 @@ func softAuthMiddleware() gin.HandlerFunc {
 		if userID != nil {
 			user, err := database.FindUserByUnique(db, userID.(string))
 			if err != nil {
-				context.AbortWithStatus(http.StatusUnauthorized)
+				renderError(context, errors.ErrUnauthorized)
 				return
-			} else {
-				context.Set("user", user)
 			}
+			if ban := database.FindActiveBan(db, user); ban != nil {
+				renderBan(context, ban)
+				return
+			}
+			context.Set("user", user)
 		}
 		context.Next()
 	}
 }
 
-func createThread(context *gin.Context) {
+// Gets the database handle for audited actions, tagged with the IP address the request came from
+func auditDB(context *gin.Context) *gorm.DB {
+	return db.Set(database.AuditIPKey, context.ClientIP())
+}
+
+// Picks the connection for a read, visitors haven't just written anything so a replica that's a little behind is fine for them
+func readerFor(user *database.User) *gorm.DB {
+	if user == nil {
+		return readDB
+	}
+	return db
+}
 
-	data := new (database.Thread)
-	err := context.BindJSON(data)
+// Gets the user set by softAuthMiddleware, nil if the request isn't authenticated
+func optionalUser(context *gin.Context) *database.User {
+	if value, exists := context.Get("user"); exists {
+		return value.(*database.User)
+	}
+	return nil
+}
 
-	// TODO: Maybe binding errors should just be blank
-	if err != nil {
-		context.AbortWithError(http.StatusBadRequest, err)
+func createThread(context *gin.Context) {
+
+	data := new (ThreadRequest)
+	if !bindRequest(context, data) {
 		return
 	}
 
 	value := context.MustGet("user")
 	user := value.(*database.User)
 
-	thread, createErr := database.CreateThread(db, user, data.Title, data.Content)
+	thread, createErr := database.CreateThreadWithPoll(db, user, data.Title, data.Content, data.Poll)
 	if createErr != nil {
 		renderError(context, createErr)
 		return
@@ -183,19 +336,21 @@ func createThread(context *gin.Context) {
 
 func addPost(context *gin.Context) {
 
-	data := new (database.Post)
-	err := context.BindJSON(data)
+	data := new (PostRequest)
 	threadId, convertErr := strconv.ParseUint(context.Param("id"), 10, 64)
 
-	if convertErr != nil || err != nil {
-		context.AbortWithStatus(http.StatusBadRequest)
+	if convertErr != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return
+	}
+	if !bindRequest(context, data) {
 		return
 	}
 
 	value := context.MustGet("user")
 	user := value.(*database.User)
 
-	post, replyErr := database.ReplyToThread(db, user, uint(threadId), data.Content)
+	post, replyErr := database.ReplyToPost(db, user, uint(threadId), data.ParentPostID, data.Content)
 	if replyErr != nil {
 		renderError(context, replyErr)
 		return
@@ -208,12 +363,32 @@ func addPost(context *gin.Context) {
 
 }
 
+func search(context *gin.Context) {
+
+	data := new (SearchRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	var threads []database.Thread
+	var posts []database.Post
+	user := optionalUser(context)
+	database.Search(readerFor(user), user, strings.TrimSpace(data.Query), data.Timestamp, data.Limit, &threads, &posts)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"threads": threads,
+		"posts": posts,
+	})
+
+}
+
 func blockUser(context *gin.Context) {
 
 	userId, err := strconv.ParseUint(context.Param("id"), 10, 64)
 
 	if err != nil {
-		context.AbortWithStatus(http.StatusBadRequest)
+		renderError(context, errors.ErrInvalidRequest)
 		return
 	}
 
@@ -237,14 +412,14 @@ func unblockUser(context *gin.Context) {
 	userId, err := strconv.ParseUint(context.Param("id"), 10, 64)
 
 	if err != nil {
-		context.AbortWithStatus(http.StatusBadRequest)
+		renderError(context, errors.ErrInvalidRequest)
 		return
 	}
 
 	value := context.MustGet("user")
 	user := value.(*database.User)
 
-	unblockError := database.UnblockUser(db, user, uint(userId))
+	unblockError := database.UnblockUser(auditDB(context), user, uint(userId))
 	if unblockError != nil {
 		renderError(context, unblockError)
 		return
@@ -256,19 +431,34 @@ func unblockUser(context *gin.Context) {
 
 }
 
+func readProfile(context *gin.Context) {
+
+	user, err := database.FindUserByUsername(db, context.Param("username"))
+	if err != nil {
+		renderError(context, err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"data": user,
+	})
+
+}
+
 func deleteThread(context *gin.Context) {
 
 	threadId, err := strconv.ParseUint(context.Param("id"), 10, 64)
 
 	if err != nil {
-		context.AbortWithStatus(http.StatusBadRequest)
+		renderError(context, errors.ErrInvalidRequest)
 		return
 	}
 
 	value := context.MustGet("user")
 	user := value.(*database.User)
 
-	if deleteErr := database.DeleteThread(db, user, uint(threadId)); deleteErr != nil {
+	if deleteErr := database.DeleteThread(auditDB(context), user, uint(threadId)); deleteErr != nil {
 		renderError(context, deleteErr)
 		return
 	}
@@ -284,14 +474,14 @@ func deletePost(context *gin.Context) {
 	postId, err := strconv.ParseUint(context.Param("id"), 10, 64)
 
 	if err != nil {
-		context.AbortWithStatus(http.StatusBadRequest)
+		renderError(context, errors.ErrInvalidRequest)
 		return
 	}
 
 	value := context.MustGet("user")
 	user := value.(*database.User)
 
-	if deleteErr := database.DeletePost(db, user, uint(postId)); deleteErr != nil {
+	if deleteErr := database.DeletePost(auditDB(context), user, uint(postId)); deleteErr != nil {
 		renderError(context, deleteErr)
 		return
 	}
@@ -303,26 +493,162 @@ func deletePost(context *gin.Context) {
 }
 
 
+// Binds the request into data, from the query for GET requests and from the JSON body (or multipart form) otherwise,
+// then validates it, rendering every violation and returning false when the request can't be used
+func bindRequest(context *gin.Context, data interface{}) bool {
+	requestBinding := binding.Default(context.Request.Method, context.ContentType())
+	if context.Request.Method != "GET" && requestBinding == binding.Form {
+		requestBinding = binding.JSON
+	}
+	if err := requestBinding.Bind(context.Request, data); err != nil {
+		renderError(context, errors.ErrInvalidRequest)
+		return false
+	}
+	if request, ok := data.(validatable); ok {
+		if err := request.Validate(); err != nil {
+			renderError(context, err)
+			return false
+		}
+	}
+	return true
+}
+
+// Responds with the error's status, stable code and message in the language the client asked for,
+// plus the fields that caused it when there are any
 func renderError(context *gin.Context, err *errors.UserError) {
-	context.JSON(http.StatusBadRequest, gin.H{
-		"status": http.StatusBadRequest,
-		"message": err.Error(),
+	context.AbortWithStatusJSON(err.Status, errorBody(context, err))
+}
+
+func errorBody(context *gin.Context, err *errors.UserError) gin.H {
+	language := errors.MatchLanguage(context.Request.Header.Get("Accept-Language"))
+	body := gin.H{
+		"status": err.Status,
+		"message": err.Message(language),
 		"error": err.Code,
-	})
+	}
+	if len(err.Details) > 0 {
+		body["details"] = err.LocalizedDetails(language)
+	}
+	return body
 }
 
-func Create(test bool) *gin.Engine {
+// Tells a banned or suspended user why, and until when for suspensions
+func renderBan(context *gin.Context, ban *database.Ban) {
+	err := ban.UserError()
+	body := errorBody(context, err)
+	body["reason"] = ban.Reason
+	body["expiresAt"] = ban.ExpiresAt
+	context.AbortWithStatusJSON(err.Status, body)
+}
+
+// Starts the periodic maintenance jobs
+func startJobs() {
+	backgroundJobs = append(backgroundJobs,
+		jobs.Every("purge-drafts", time.Hour, func() { database.PurgeExpiredDrafts(db) }),
+		jobs.Every("purge-audit-log", time.Hour * 24, func() { database.PurgeAuditLog(db) }),
+		jobs.Every("purge-deleted-content", time.Hour, func() {
+			if purgeErr, ok := database.PurgeDeletedContent(db, blobs).(*database.PurgeError); ok {
+				for _, failure := range purgeErr.Failures {
+					log.Printf("Purging deleted content failed for %v", failure)
+				}
+			}
+		}),
+	)
+}
+
+// Stops the periodic maintenance jobs, waiting for any that are running to finish
+func StopJobs() {
+	for _, job := range backgroundJobs {
+		job.Stop()
+	}
+	backgroundJobs = nil
+}
+
+// Builds the router, connecting to (and migrating) the database first. Nothing is started if any of it fails
+func Create(test bool) (*gin.Engine, error) {
 
-	db = database.MakeConnection(test)
 	configData, err := config.LoadConfigWithViper()
 	if err != nil {
-		panic("Issue loading config file")
+		return nil, err
+	}
+
+	markdown.AllowImages = !configData.DisableImages
+	database.HidePollResultsUntilVoted = !configData.ShowPollResultsBeforeVoting
+	if len(configData.Reactions) > 0 {
+		helpers.AllowedReactions = configData.Reactions
+	}
+	if err := helpers.ApplyPolicy(&configData.Validation); err != nil {
+		return nil, err
+	}
+	if configData.MaxAttachmentSize > 0 {
+		database.MaxAttachmentSize = configData.MaxAttachmentSize
+	}
+	if configData.AttachmentQuota > 0 {
+		database.AttachmentQuota = configData.AttachmentQuota
+	}
+	if configData.DraftExpiryHours > 0 {
+		database.DraftExpiry = time.Hour * time.Duration(configData.DraftExpiryHours)
+	}
+
+	if configData.DisableSpamFilter {
+		database.SpamPipeline = nil
+	} else {
+		database.SpamPipeline = spam.DefaultPipeline(configData.SpamBlockedDomains)
+		if configData.SpamHoldScore > 0 {
+			database.SpamPipeline.HoldScore = configData.SpamHoldScore
+		}
+		if configData.SpamRejectScore > 0 {
+			database.SpamPipeline.RejectScore = configData.SpamRejectScore
+		}
+	}
+
+	if configData.EnableTrustLevels {
+		database.TrustLevels = database.DefaultTrustLevels
+	}
+	if configData.AuditRetentionDays > 0 {
+		database.AuditRetention = time.Hour * 24 * time.Duration(configData.AuditRetentionDays)
+	}
+	if configData.PostRateLimit > 0 {
+		database.PostRateLimit = configData.PostRateLimit
+	}
+
+	// The client IP decides IP bans and the audit log, so forwarded headers only count from proxies we were told about
+	ginRouter := gin.Default()
+	if err := ginRouter.SetTrustedProxies(configData.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("can't use the trusted proxies: %v", err)
+	}
+
+	blobs, err = createBlobStore(configData)
+	if err != nil {
+		return nil, fmt.Errorf("can't create the attachment store: %v", err)
+	}
+
+	db, err = database.Connect(configData, test)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.Setup(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("can't migrate the database: %v", err)
+	}
+
+	readDB, err = database.ConnectReplica(configData, test)
+	if err != nil {
+		db.Close()
+		return nil, err
+	} else if readDB == nil {
+		readDB = db
 	}
 
 	// TODO: Maybe change to a memcache or redis store
 	store := sessions.NewCookieStore([]byte(configData.Secret))
-	database.Setup(db)
-	ginRouter := gin.Default()
+	database.PromoteAdmins(db, configData.Admins)
+	startJobs()
+
+	// Health checks come before the session middleware, probes don't need a session and shouldn't be handed cookies
+	ginRouter.GET("/healthz", checkHealth)
+	ginRouter.GET("/readyz", checkReadiness)
+
 	ginRouter.Use(sessions.Sessions("mysession", store))
 
 	auth := ginRouter.Group("/auth/login")
@@ -330,18 +656,39 @@ func Create(test bool) *gin.Engine {
 		auth.POST("/", login)
 	}
 
+	ginRouter.GET("/api/v1/search", softAuthMiddleware(), search)
+
+	meta := ginRouter.Group("/api/v1/meta")
+	{
+		meta.GET("/rules", readRules)
+	}
+
 	threads := ginRouter.Group("/api/v1/threads")
 	{
 		threads.GET("/latest", softAuthMiddleware(), readLatestThreads)
-		threads.GET("/responses/:id", readLatestPosts)
+		threads.GET("/view/:id", softAuthMiddleware(), readThread)
+		threads.GET("/responses/:id", softAuthMiddleware(), readLatestPosts)
 		threads.POST("/new", authMiddleware(), createThread)
 		threads.POST("/reply/:id", authMiddleware(), addPost)
 		threads.POST("/delete/:id", authMiddleware(), deleteThread)
+		threads.POST("/restore/:id", authMiddleware(), restoreContent(database.TargetThread))
+		threads.POST("/react/:id", authMiddleware(), addReaction(database.TargetThread))
+		threads.POST("/unreact/:id", authMiddleware(), removeReaction(database.TargetThread))
+		threads.GET("/reactions/:id", softAuthMiddleware(), readReactions(database.TargetThread))
+		threads.POST("/vote/:id", authMiddleware(), voteInPoll)
+		threads.POST("/read/:id", authMiddleware(), markThreadRead)
+		threads.POST("/readall", authMiddleware(), markAllRead)
+		threads.POST("/flag/:id", authMiddleware(), flagContent(database.TargetThread))
 	}
 
 	posts := ginRouter.Group("/api/v1/posts")
 	{
 		posts.POST("/delete/:id", authMiddleware(), deletePost)
+		posts.POST("/restore/:id", authMiddleware(), restoreContent(database.TargetPost))
+		posts.POST("/react/:id", authMiddleware(), addReaction(database.TargetPost))
+		posts.POST("/unreact/:id", authMiddleware(), removeReaction(database.TargetPost))
+		posts.GET("/reactions/:id", softAuthMiddleware(), readReactions(database.TargetPost))
+		posts.POST("/flag/:id", authMiddleware(), flagContent(database.TargetPost))
 	}
 
 	users := ginRouter.Group("/api/v1/users")
@@ -349,8 +696,75 @@ func Create(test bool) *gin.Engine {
 		users.POST("/block/:id", authMiddleware(), blockUser)
 		users.POST("/unblock/:id", authMiddleware(), unblockUser)
 		users.POST("/new", register)
+		users.GET("/profile/:username", readProfile)
+		users.GET("/export", authMiddleware(), exportUserData)
+		users.GET("/trash", authMiddleware(), readTrash)
+	}
+
+	attachments := ginRouter.Group("/api/v1/attachments")
+	{
+		attachments.POST("/upload", authMiddleware(), uploadAttachment)
+		attachments.GET("/download/:id", softAuthMiddleware(), downloadAttachment)
+	}
+
+	conversations := ginRouter.Group("/api/v1/conversations")
+	{
+		conversations.POST("/new", authMiddleware(), startConversation)
+		conversations.GET("/latest", authMiddleware(), readConversations)
+		conversations.GET("/unread", authMiddleware(), readUnreadMessages)
+		conversations.GET("/messages/:id", authMiddleware(), readMessages)
+		conversations.POST("/reply/:id", authMiddleware(), sendMessage)
+		conversations.POST("/read/:id", authMiddleware(), readConversation)
+		conversations.POST("/leave/:id", authMiddleware(), leaveConversation)
+	}
+
+	bookmarks := ginRouter.Group("/api/v1/bookmarks")
+	{
+		bookmarks.POST("/new", authMiddleware(), addBookmark)
+		bookmarks.POST("/update/:id", authMiddleware(), updateBookmark)
+		bookmarks.POST("/delete/:id", authMiddleware(), deleteBookmark)
+		bookmarks.GET("/latest", authMiddleware(), readBookmarks)
+	}
+
+	drafts := ginRouter.Group("/api/v1/drafts")
+	{
+		drafts.POST("/save", authMiddleware(), saveDraft)
+		drafts.GET("/latest", authMiddleware(), readDrafts)
+		drafts.GET("/view/:id", authMiddleware(), readDraft)
+		drafts.POST("/delete/:id", authMiddleware(), deleteDraft)
+	}
+
+	notifications := ginRouter.Group("/api/v1/notifications")
+	{
+		notifications.GET("/latest", authMiddleware(), readNotifications)
+		notifications.POST("/read/:id", authMiddleware(), readNotification)
+	}
+
+	moderation := ginRouter.Group("/api/v1/moderation", authMiddleware(), moderatorMiddleware())
+	{
+		moderation.GET("/held", readHeldContent)
+		moderation.GET("/flags", readFlags)
+		moderation.POST("/ban", issueBan)
+		moderation.GET("/bans", readBans)
+		moderation.POST("/lift/:id", liftBan)
+		moderation.POST("/shadowban/:id", shadowBan(true))
+		moderation.POST("/unshadowban/:id", shadowBan(false))
+		moderation.POST("/approve/thread/:id", moderate(database.TargetThread, true))
+		moderation.POST("/approve/post/:id", moderate(database.TargetPost, true))
+		moderation.POST("/reject/thread/:id", moderate(database.TargetThread, false))
+		moderation.POST("/reject/post/:id", moderate(database.TargetPost, false))
+	}
+
+	admin := ginRouter.Group("/api/v1/admin", authMiddleware(), adminMiddleware())
+	{
+		admin.POST("/role/:id", setUserRole)
+		admin.GET("/trust/:id", readTrustLevel)
+		admin.POST("/trust/:id", setTrustLevel)
+		admin.GET("/audit", readAuditLog)
+		admin.POST("/reconcile", reconcileCounters)
 	}
 
-	return ginRouter
+	atomic.StoreInt32(&draining, 0)
+	return ginRouter, nil
 
 }
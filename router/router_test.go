@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"ForumDatabase/database"
 	"net/http/cookiejar"
+	"mime/multipart"
 )
 
 type Response struct {
@@ -19,13 +20,27 @@ type Response struct {
 }
 
 var (
-	server *httptest.Server = httptest.NewServer(Create(true))
+	server *httptest.Server = httptest.NewServer(mustCreate())
 	TYPE_JSON = "application/json"
 )
 
+func mustCreate() http.Handler {
+	engine, err := Create(true)
+	if err != nil {
+		panic(err)
+	}
+	return engine
+}
+
 func TestClear(t *testing.T) {
-	db := database.MakeConnection(true)
-	db.Exec("DROP TABLE block_records, posts, thread_posts, threads, user_posts, user_threads, users")
+	database.SpamPipeline = nil
+	database.TrustLevels = nil
+	database.PostRateLimit = 0
+	db, err := database.MakeConnection(true)
+	if err != nil {
+		t.Fatal("Unexpected error connecting: ", err)
+	}
+	db.Exec("DROP TABLE block_records, posts, thread_posts, threads, user_posts, user_threads, users, mentions, notifications, quotes, reactions, polls, poll_options, poll_votes, attachments, conversations, conversation_participants, messages, drafts, read_positions, bookmarks, flags, bans, audit_entries")
 	database.Setup(db)
 	db.Close()
 }
@@ -112,6 +127,53 @@ func TestRegister3(t *testing.T) {
 	}
 }
 
+func TestRegisterInvalid(t *testing.T) {
+	client := createClient()
+	data := createJson(map[string]string{"username": "a b", "password": "short"})
+	httpRes, _ := client.Post(server.URL + "/api/v1/users/new", TYPE_JSON, data)
+	var response struct {
+		Error string `json:"error"`
+		Details []map[string]interface{} `json:"details"`
+	}
+	json.NewDecoder(httpRes.Body).Decode(&response)
+	if httpRes.StatusCode != http.StatusBadRequest || response.Error != "validation_failed" || len(response.Details) < 3 {
+		t.Error("Expected every invalid field to be reported at once, got ", response)
+	}
+}
+
+func TestReadRules(t *testing.T) {
+	httpRes, err := http.Get(server.URL + "/api/v1/meta/rules")
+	if err != nil {
+		t.Error("Error getting the validation rules: ", err)
+		return
+	}
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	json.NewDecoder(httpRes.Body).Decode(&response)
+	if response.Data["title"] == nil || response.Data["password"] == nil {
+		t.Error("Expected the title and password rules, got ", response.Data)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	for _, path := range []string{"/healthz", "/readyz"} {
+		httpRes, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Error("Error checking " + path + ": ", err)
+			continue
+		}
+		var response struct {
+			Status int `json:"status"`
+			Data map[string]string `json:"data"`
+		}
+		json.NewDecoder(httpRes.Body).Decode(&response)
+		if httpRes.StatusCode != http.StatusOK || response.Data["database"] != "ok" || response.Data["migrations"] != "ok" {
+			t.Error("Expected " + path + " to report healthy, got ", httpRes.StatusCode, response.Data)
+		}
+	}
+}
+
 func TestLogin(t *testing.T) {
 	client := createClient()
 	loginWithCredentials(t, client, &database.TEST_USER1)
@@ -127,6 +189,19 @@ func TestCreateThread(t *testing.T) {
 	}
 }
 
+func TestCreateThreadWithPoll(t *testing.T) {
+	client := createClient()
+	loginWithCredentials(t, client, &database.TEST_USER1)
+	poll := map[string]interface{}{"question": "Which one do you prefer?", "options": []string{"Tabs", "Spaces"}}
+	data := createJson(map[string]interface{}{"title": "Thread with a poll on it", "content": "Vote for your favourite option below", "poll": poll})
+	httpRes, _ := client.Post(server.URL + "/api/v1/threads/new", TYPE_JSON, data)
+	var response Response
+	bindResponse(httpRes.Body, &response)
+	if response.Status != http.StatusOK {
+		t.Error("Unexpected issue creating a thread with a poll")
+	}
+}
+
 func TestReplyToThread(t *testing.T)  {
 	client := createClient()
 	loginWithCredentials(t, client, &database.TEST_USER1)
@@ -155,6 +230,89 @@ func TestUnblockUser(t *testing.T) {
 	}
 }
 
+func TestReadNotifications(t *testing.T) {
+	client := createClient()
+	loginWithCredentials(t, client, &database.TEST_USER2)
+	timestampString := strconv.FormatInt(database.MakeTimestamp(), 10)
+	httpRes, err := client.Get(server.URL + "/api/v1/notifications/latest?timestamp=" + timestampString + "&limit=10")
+	if err != nil {
+		t.Error("Error getting notifications: ", err)
+		return
+	}
+	var response Response
+	bindResponse(httpRes.Body, &response)
+	if response.Status != http.StatusOK {
+		t.Error("Unexpected issue reading notifications")
+	}
+}
+
+func TestAddReaction(t *testing.T) {
+	client := createClient()
+	loginWithCredentials(t, client, &database.TEST_USER1)
+	data := createJson(map[string]string{"emoji": "+1"})
+	httpRes, _ := client.Post(server.URL + "/api/v1/posts/react/1", TYPE_JSON, data)
+	var response Response
+	bindResponse(httpRes.Body, &response)
+	if response.Status != http.StatusOK {
+		t.Error("Unexpected issue reacting to post")
+	}
+}
+
+func TestUploadAttachment(t *testing.T) {
+	client := createClient()
+	loginWithCredentials(t, client, &database.TEST_USER1)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("threadId", "1")
+	part, _ := writer.CreateFormFile("file", "notes.txt")
+	part.Write([]byte("Some plain text notes to attach to the thread"))
+	writer.Close()
+
+	httpRes, _ := client.Post(server.URL + "/api/v1/attachments/upload", writer.FormDataContentType(), &body)
+	var response Response
+	bindResponse(httpRes.Body, &response)
+	if response.Status != http.StatusOK {
+		t.Error("Unexpected issue uploading attachment")
+	}
+}
+
+func TestSaveDraft(t *testing.T) {
+	client := createClient()
+	loginWithCredentials(t, client, &database.TEST_USER1)
+	data := createJson(map[string]interface{}{"threadId": 1, "content": "A reply I haven't finished"})
+	httpRes, _ := client.Post(server.URL + "/api/v1/drafts/save", TYPE_JSON, data)
+	var response Response
+	bindResponse(httpRes.Body, &response)
+	if response.Status != http.StatusOK {
+		t.Error("Unexpected issue saving draft")
+	}
+}
+
+func TestBannedSession(t *testing.T) {
+	banned := database.User{Username: "bannedreader", Password: "bannedpassword1"}
+	registerUser(&banned)
+	client := createClient()
+	loginWithCredentials(t, client, &banned)
+
+	db, err := database.MakeConnection(true)
+	if err != nil {
+		t.Fatal("Unexpected error connecting: ", err)
+	}
+	defer db.Close()
+	user, _ := database.FindUserByCredentials(db, banned.Username, banned.Password)
+	db.Create(&database.Ban{Kind: database.BanUser, UserID: user.ID, Reason: "Testing", Timestamp: database.MakeTimestamp()})
+
+	httpRes, _ := client.Get(server.URL + "/api/v1/threads/latest")
+	var response struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(httpRes.Body).Decode(&response)
+	if httpRes.StatusCode != http.StatusForbidden || response.Error != "banned" {
+		t.Error("Expected a banned session to get the ban error on public routes, got ", httpRes.StatusCode, response.Error)
+	}
+}
+
 func TestDeletePost(t *testing.T) {
 	client := createClient()
 	loginWithCredentials(t, client, &database.TEST_USER1)
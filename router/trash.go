@@ -0,0 +1,62 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"ForumDatabase/database"
+	"ForumDatabase/errors"
+	"net/http"
+	"strconv"
+)
+
+func restoreContent(targetType string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+
+		targetId, err := strconv.ParseUint(context.Param("id"), 10, 64)
+		if err != nil {
+			renderError(context, errors.ErrInvalidRequest)
+			return
+		}
+
+		value := context.MustGet("user")
+		user := value.(*database.User)
+
+		var restoreErr *errors.UserError
+		if targetType == database.TargetThread {
+			restoreErr = database.RestoreThread(auditDB(context), user, uint(targetId))
+		} else {
+			restoreErr = database.RestorePost(auditDB(context), user, uint(targetId))
+		}
+
+		if restoreErr != nil {
+			renderError(context, restoreErr)
+			return
+		}
+
+		context.JSON(http.StatusOK, gin.H {
+			"status": http.StatusOK,
+		})
+
+	}
+}
+
+func readTrash(context *gin.Context) {
+
+	data := new (QueryRequest)
+	if !bindRequest(context, data) {
+		return
+	}
+
+	value := context.MustGet("user")
+	user := value.(*database.User)
+
+	var threads []database.Thread
+	var posts []database.Post
+	database.GetTrash(db, user, data.Timestamp, data.Limit, &threads, &posts)
+
+	context.JSON(http.StatusOK, gin.H{
+		"status": http.StatusOK,
+		"threads": threads,
+		"posts": posts,
+	})
+
+}
@@ -0,0 +1,205 @@
+package spam
+
+import (
+	"ForumDatabase/markdown"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	Hold
+	Reject
+)
+
+// What the checks get to look at for a new thread or post
+type Content struct {
+	Text string
+	AccountAge time.Duration
+	RecentTexts []string
+	RecentCount int
+}
+
+// Scores one signal, 0 meaning nothing suspicious
+type Check interface {
+	Name() string
+	Score(content *Content) float64
+}
+
+type Result struct {
+	Score float64
+	Verdict Verdict
+	Reasons []string
+}
+
+// Runs every check and adds up the scores, holding or rejecting content past the thresholds
+type Pipeline struct {
+	Checks []Check
+	HoldScore float64
+	RejectScore float64
+}
+
+func (pipeline *Pipeline) Run(content *Content) Result {
+	var result Result
+	for _, check := range pipeline.Checks {
+		if score := check.Score(content); score > 0 {
+			result.Score += score
+			result.Reasons = append(result.Reasons, check.Name())
+		}
+	}
+
+	if result.Score >= pipeline.RejectScore {
+		result.Verdict = Reject
+	} else if result.Score >= pipeline.HoldScore {
+		result.Verdict = Hold
+	}
+	return result
+}
+
+// Creates the pipeline with every built in check at its default weight
+func DefaultPipeline(blockedDomains []string) *Pipeline {
+	return &Pipeline{
+		Checks: []Check{
+			&AccountAgeCheck{MinAge: time.Hour * 24, Weight: 1.5},
+			&LinkDensityCheck{MaxLinks: 2, MaxDensity: 0.3, Weight: 1},
+			&DuplicateCheck{Weight: 3},
+			&VelocityCheck{MaxPosts: 5, Weight: 1},
+			&DomainBlocklistCheck{Domains: blockedDomains, Weight: 10},
+		},
+		HoldScore: 3,
+		RejectScore: 6,
+	}
+}
+
+// Finds the links in text, found by rendering it so anything the renderer would link is counted
+func Links(text string) []string {
+	return markdown.Links(text)
+}
+
+// Gets the host a link points at, the domain of the address for mailto links
+func linkHost(link string) string {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	if parsed.Scheme == "mailto" {
+		address := parsed.Opaque
+		return strings.ToLower(address[strings.LastIndex(address, "@") + 1:])
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// Scores accounts younger than MinAge, the newest scoring highest
+type AccountAgeCheck struct {
+	MinAge time.Duration
+	Weight float64
+}
+
+func (check *AccountAgeCheck) Name() string {
+	return "account_age"
+}
+
+func (check *AccountAgeCheck) Score(content *Content) float64 {
+	if content.AccountAge >= check.MinAge {
+		return 0
+	}
+	return check.Weight * (1 - float64(content.AccountAge) / float64(check.MinAge))
+}
+
+// Scores each link over MaxLinks, and content that's mostly links
+type LinkDensityCheck struct {
+	MaxLinks int
+	MaxDensity float64
+	Weight float64
+}
+
+func (check *LinkDensityCheck) Name() string {
+	return "link_density"
+}
+
+func (check *LinkDensityCheck) Score(content *Content) float64 {
+	links := len(Links(content.Text))
+	if links == 0 {
+		return 0
+	}
+
+	var score float64
+	if links > check.MaxLinks {
+		score += check.Weight * float64(links - check.MaxLinks)
+	}
+
+	words := len(strings.Fields(content.Text))
+	if float64(links) / float64(words) > check.MaxDensity {
+		score += check.Weight * 2
+	}
+	return score
+}
+
+// Scores content the author has already posted recently
+type DuplicateCheck struct {
+	Weight float64
+}
+
+func (check *DuplicateCheck) Name() string {
+	return "duplicate"
+}
+
+func normalize(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+func (check *DuplicateCheck) Score(content *Content) float64 {
+	text := normalize(content.Text)
+	for _, recent := range content.RecentTexts {
+		if normalize(recent) == text {
+			return check.Weight
+		}
+	}
+	return 0
+}
+
+// Scores every recent post at or past MaxPosts
+type VelocityCheck struct {
+	MaxPosts int
+	Weight float64
+}
+
+func (check *VelocityCheck) Name() string {
+	return "velocity"
+}
+
+func (check *VelocityCheck) Score(content *Content) float64 {
+	if content.RecentCount < check.MaxPosts {
+		return 0
+	}
+	return check.Weight * float64(content.RecentCount - check.MaxPosts + 1)
+}
+
+// Scores links to any of the blocked domains or their subdomains
+type DomainBlocklistCheck struct {
+	Domains []string
+	Weight float64
+}
+
+func (check *DomainBlocklistCheck) Name() string {
+	return "blocked_domain"
+}
+
+func (check *DomainBlocklistCheck) Score(content *Content) float64 {
+	for _, link := range Links(content.Text) {
+		host := linkHost(link)
+		if host == "" {
+			continue
+		}
+		for _, domain := range check.Domains {
+			domain = strings.ToLower(domain)
+			if host == domain || strings.HasSuffix(host, "." + domain) {
+				return check.Weight
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package spam
+
+import (
+	"testing"
+	"time"
+)
+
+var established = time.Hour * 24 * 365
+
+func TestAllow(t *testing.T) {
+	pipeline := DefaultPipeline(nil)
+	result := pipeline.Run(&Content{Text: "Has anyone tried the new release yet? See https://example.com/notes", AccountAge: established})
+	if result.Verdict != Allow {
+		t.Error("Expected normal content to be allowed: ", result)
+	}
+}
+
+func TestHoldLinkSpamFromNewAccount(t *testing.T) {
+	pipeline := DefaultPipeline(nil)
+	text := "cheap https://a.example https://b.example https://c.example"
+	result := pipeline.Run(&Content{Text: text, AccountAge: time.Minute})
+	if result.Verdict != Hold {
+		t.Error("Expected link heavy content from a new account to be held: ", result)
+	}
+}
+
+func TestRejectBlockedDomain(t *testing.T) {
+	pipeline := DefaultPipeline([]string{"spam.example"})
+	result := pipeline.Run(&Content{Text: "Visit https://shop.spam.example/deals today", AccountAge: established})
+	if result.Verdict != Reject {
+		t.Error("Expected blocked domain to be rejected: ", result)
+	}
+}
+
+func TestBareLinks(t *testing.T) {
+	pipeline := DefaultPipeline([]string{"spam.example"})
+
+	if links := Links("visit www.spam.example now please"); len(links) != 1 {
+		t.Error("Expected a bare www. host to count as a link: ", links)
+	}
+	result := pipeline.Run(&Content{Text: "visit www.spam.example now please", AccountAge: established})
+	if result.Verdict != Reject {
+		t.Error("Expected a bare www. link to a blocked domain to be rejected: ", result)
+	}
+
+	result = pipeline.Run(&Content{Text: "write to deals@spam.example for offers", AccountAge: established})
+	if result.Verdict != Reject {
+		t.Error("Expected an email address at a blocked domain to be rejected: ", result)
+	}
+
+	result = pipeline.Run(&Content{Text: "cheap www.a.example www.b.example www.c.example", AccountAge: time.Minute})
+	if result.Verdict != Hold {
+		t.Error("Expected bare link spam from a new account to be held: ", result)
+	}
+
+	if links := Links("Code like `www.example.com` isn't a link"); len(links) != 0 {
+		t.Error("Expected text inside code not to count as a link: ", links)
+	}
+}
+
+func TestDuplicateAndVelocity(t *testing.T) {
+	pipeline := DefaultPipeline(nil)
+	content := Content{
+		Text: "Buy   now, limited offer",
+		AccountAge: established,
+		RecentTexts: []string{"buy now, LIMITED offer"},
+		RecentCount: 5,
+	}
+	result := pipeline.Run(&content)
+	if result.Verdict != Hold || len(result.Reasons) != 2 {
+		t.Error("Expected duplicate content posted quickly to be held: ", result)
+	}
+}